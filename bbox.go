@@ -0,0 +1,247 @@
+package hobby
+
+import (
+	"math"
+
+	"github.com/boxesandglue/mpgo/mp"
+	"github.com/boxesandglue/mpgo/svg"
+)
+
+// miterLimit is the miter limit used for bounding boxes. It matches the
+// SVG default (stroke-miterlimit) and the envelope code of mpgo.
+const miterLimit = 4.0
+
+// bbox is an axis-aligned bounding box. The zero value is empty.
+type bbox struct {
+	minX, minY, maxX, maxY float64
+	valid                  bool
+}
+
+// add extends b to include the given box.
+func (b *bbox) add(minX, minY, maxX, maxY float64) {
+	if !b.valid {
+		*b = bbox{minX, minY, maxX, maxY, true}
+		return
+	}
+	b.minX, b.minY = math.Min(b.minX, minX), math.Min(b.minY, minY)
+	b.maxX, b.maxY = math.Max(b.maxX, maxX), math.Max(b.maxY, maxY)
+}
+
+// addBox extends b to include o.
+func (b *bbox) addBox(o bbox) {
+	if o.valid {
+		b.add(o.minX, o.minY, o.maxX, o.maxY)
+	}
+}
+
+// addPoint extends b to include the point (x, y).
+func (b *bbox) addPoint(x, y float64) {
+	b.add(x, y, x, y)
+}
+
+// clipped returns the part of b inside the bounding box of the clip path.
+func (b bbox) clipped(clip *mp.Path) bbox {
+	if !b.valid || clip == nil || clip.Head == nil {
+		return b
+	}
+	minX, minY, maxX, maxY := svg.PathBBox(clip)
+	r := bbox{
+		math.Max(b.minX, minX), math.Max(b.minY, minY),
+		math.Min(b.maxX, maxX), math.Min(b.maxY, maxY), true,
+	}
+	if r.minX > r.maxX || r.minY > r.maxY {
+		return bbox{}
+	}
+	return r
+}
+
+// pathBBox returns the bounding box of the path geometry.
+func pathBBox(p *mp.Path) bbox {
+	if p == nil || p.Head == nil {
+		return bbox{}
+	}
+	var b bbox
+	b.add(svg.PathBBox(p))
+	return b
+}
+
+// isStroked reports whether p is drawn with a stroke. Paths without a
+// stroke color are stroked in black, see svgWriter.writePath.
+func isStroked(p *mp.Path) bool {
+	return p.Style.Stroke.CSS() != "none"
+}
+
+// penExtent returns how far the pen of p reaches from the path in each
+// direction: the pen's bounding box relative to the point it is drawn at.
+// Paths without a pen use a circle with their stroke width.
+func penExtent(p *mp.Path) (minX, minY, maxX, maxY float64) {
+	pen := p.Style.Pen
+	switch {
+	case pen != nil && pen.Head != nil && pen.Elliptical:
+		// The pen knot stores the images of (1,0) and (0,1) of the
+		// transformed unit diameter circle.
+		k := pen.Head
+		a, b := k.LeftX-k.XCoord, k.RightX-k.XCoord
+		c, d := k.LeftY-k.YCoord, k.RightY-k.YCoord
+		hx, hy := math.Hypot(a, b)/2, math.Hypot(c, d)/2
+		return k.XCoord - hx, k.YCoord - hy, k.XCoord + hx, k.YCoord + hy
+	case pen != nil && pen.Head != nil:
+		if minX, minY, maxX, maxY, ok := mp.PenBBox(pen); ok {
+			return minX, minY, maxX, maxY
+		}
+	}
+	w := strokeWidth(p) / 2
+	return -w, -w, w, w
+}
+
+// pathTrueBBox returns the area covered by p as it is drawn: the swept
+// pen or stroke width, miter joins, square caps and arrow heads. Like
+// MetaPost the bounding box of the sweep is the path's bounding box
+// enlarged by the pen's.
+func pathTrueBBox(p *mp.Path) bbox {
+	if p == nil || p.Head == nil {
+		return bbox{}
+	}
+	if envelope := penEnvelope(p); envelope != nil {
+		b := pathBBox(envelope)
+		b.addBox(arrowHeadsBBox(p))
+		return b
+	}
+	b := pathBBox(p)
+	if !isStroked(p) {
+		return b
+	}
+	pMinX, pMinY, pMaxX, pMaxY := penExtent(p)
+	b = bbox{b.minX + pMinX, b.minY + pMinY, b.maxX + pMaxX, b.maxY + pMaxY, true}
+
+	half := strokeWidth(p) / 2
+	if p.Style.LineJoin == mp.LineJoinMiter {
+		knots := pathKnots(p)
+		cyclic := isCycle(p)
+		for i, k := range knots {
+			if !cyclic && (i == 0 || i == len(knots)-1) {
+				continue
+			}
+			if x, y, ok := miterTip(k, half); ok {
+				b.addPoint(x, y)
+			}
+		}
+	}
+	if p.Style.LineCap == mp.LineCapSquared && !isCycle(p) {
+		start := startDirection(p, mp.P(1, 0))
+		end := endDirection(p, mp.P(1, 0))
+		first, last := p.Head, lastKnot(p)
+		addSquareCap(&b, first.XCoord, first.YCoord, -start.X, -start.Y, half)
+		addSquareCap(&b, last.XCoord, last.YCoord, end.X, end.Y, half)
+	}
+	b.addBox(arrowHeadsBBox(p))
+	return b
+}
+
+// arrowHeadsBBox returns the bounding box of the arrow heads of p.
+func arrowHeadsBBox(p *mp.Path) bbox {
+	var b bbox
+	length, angle := arrowSize(p)
+	if p.Style.Arrow.End {
+		b.addBox(pathBBox(mp.ArrowHeadEnd(p, length, angle)))
+	}
+	if p.Style.Arrow.Start {
+		b.addBox(pathBBox(mp.ArrowHeadStart(p, length, angle)))
+	}
+	return b
+}
+
+// knotDirections returns the unit directions into and out of k, taken
+// from the control points or, if these coincide with the knot, from the
+// neighbouring knots.
+func knotDirections(k *mp.Knot) (inX, inY, outX, outY float64, ok bool) {
+	inX, inY = k.XCoord-k.LeftX, k.YCoord-k.LeftY
+	if math.Hypot(inX, inY) < joinTolerance {
+		inX, inY = k.XCoord-k.Prev.XCoord, k.YCoord-k.Prev.YCoord
+	}
+	outX, outY = k.RightX-k.XCoord, k.RightY-k.YCoord
+	if math.Hypot(outX, outY) < joinTolerance {
+		outX, outY = k.Next.XCoord-k.XCoord, k.Next.YCoord-k.YCoord
+	}
+	lin, lout := math.Hypot(inX, inY), math.Hypot(outX, outY)
+	if lin < joinTolerance || lout < joinTolerance {
+		return 0, 0, 0, 0, false
+	}
+	return inX / lin, inY / lin, outX / lout, outY / lout, true
+}
+
+// miterTip returns the tip of the miter join at k for a stroke of half
+// width half. ok is false if there is no corner or the miter exceeds the
+// miter limit (the join is then beveled and stays inside the sweep).
+func miterTip(k *mp.Knot, half float64) (x, y float64, ok bool) {
+	inX, inY, outX, outY, ok := knotDirections(k)
+	if !ok {
+		return 0, 0, false
+	}
+	// sin of half the angle between the two stroke edges
+	sinHalf := math.Sqrt(math.Max(0, (1+inX*outX+inY*outY)/2))
+	bx, by := inX-outX, inY-outY
+	bl := math.Hypot(bx, by)
+	if bl < 1e-9 || sinHalf < 1/miterLimit {
+		return 0, 0, false
+	}
+	dist := half / sinHalf
+	return k.XCoord + bx/bl*dist, k.YCoord + by/bl*dist, true
+}
+
+// addSquareCap adds the corners of a square cap at (x, y) pointing in
+// direction (dx, dy).
+func addSquareCap(b *bbox, x, y, dx, dy, half float64) {
+	l := math.Hypot(dx, dy)
+	dx, dy = dx/l*half, dy/l*half
+	b.addPoint(x+dx-dy, y+dy+dx)
+	b.addPoint(x+dx+dy, y+dy-dx)
+}
+
+// labelTrueBBox returns the bounding box of a label. With a font the
+// glyph outlines are measured, otherwise the size is estimated.
+func labelTrueBBox(label *mp.Label, glyphs mp.FontRenderer) (bbox, error) {
+	var b bbox
+	if glyphs == nil {
+		b.add(label.EstimateBounds())
+		return b, nil
+	}
+	outlines, err := label.ToPaths(glyphs)
+	if err != nil {
+		return bbox{}, err
+	}
+	for _, o := range outlines {
+		b.addBox(pathBBox(o))
+	}
+	return b, nil
+}
+
+// pictureTrueBBox returns the area covered by the drawn contents of pic,
+// see pathTrueBBox. The paths are measured with the styles of the
+// pictures and items resolved as in the SVG output, defaults being the
+// style inherited from the enclosing pictures and the theme th. Clipping
+// paths are taken into account, bounds set with setbounds are not (like
+// MetaPost with truecorners).
+func pictureTrueBBox(pic *picture, glyphs mp.FontRenderer, defaults *mp.Style, th *theme) (bbox, error) {
+	defaults = inheritStyle(defaults, pic.style, th)
+	var b bbox
+	for _, it := range pic.items {
+		switch {
+		case it.path != nil:
+			b.addBox(pathTrueBBox(styledPath(it.path, inheritStyle(defaults, it.style, th))))
+		case it.label != nil:
+			lb, err := labelTrueBBox(it.label, glyphs)
+			if err != nil {
+				return bbox{}, err
+			}
+			b.addBox(lb)
+		case it.group != nil:
+			gb, err := pictureTrueBBox(it.group, glyphs, defaults, th)
+			if err != nil {
+				return bbox{}, err
+			}
+			b.addBox(gb)
+		}
+	}
+	return b.clipped(pic.clip), nil
+}
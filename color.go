@@ -1,15 +1,20 @@
 package hobby
 
 import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
 	"github.com/boxesandglue/mpgo/mp"
 	lua "github.com/speedata/go-lua"
 )
 
 // checkColor extracts a color from a Lua argument.
 // Accepts:
-//   - string: CSS color ("red", "#ff0000", "rgb(255,0,0)")
-//   - table with r,g,b fields (0-1 range)
-//   - table with 3 numbers {r, g, b} (0-1 range)
+//   - string: CSS color ("red", "#ff0000", "#ff000080", "rgba(255,0,0,0.5)")
+//   - table with r,g,b and optional a fields (0-1 range)
+//   - table with 3 or 4 numbers {r, g, b[, a]} (0-1 range)
 //   - 3 numbers on the stack starting at index (r, g, b in 0-1 range)
 func checkColor(l *lua.State, index int) mp.Color {
 	// Check for colorWrapper userdata first
@@ -22,8 +27,7 @@ func checkColor(l *lua.State, index int) mp.Color {
 	switch {
 	case l.IsString(index):
 		// CSS color string
-		css := lua.CheckString(l, index)
-		return mp.ColorCSS(css)
+		return cssColor(lua.CheckString(l, index))
 
 	case l.IsTable(index):
 		// Try {r=, g=, b=} first
@@ -37,6 +41,11 @@ func checkColor(l *lua.State, index int) mp.Color {
 			l.Field(index, "b")
 			b, _ := l.ToNumber(-1)
 			l.Pop(1)
+			l.Field(index, "a")
+			defer l.Pop(1)
+			if a, ok := l.ToNumber(-1); ok {
+				return mp.ColorRGBA(r, g, b, a)
+			}
 			return mp.ColorRGB(r, g, b)
 		}
 		l.Pop(1)
@@ -51,6 +60,11 @@ func checkColor(l *lua.State, index int) mp.Color {
 		l.RawGetInt(index, 3)
 		b, _ := l.ToNumber(-1)
 		l.Pop(1)
+		l.RawGetInt(index, 4)
+		defer l.Pop(1)
+		if a, ok := l.ToNumber(-1); ok {
+			return mp.ColorRGBA(r, g, b, a)
+		}
 		return mp.ColorRGB(r, g, b)
 
 	case l.IsNumber(index):
@@ -74,6 +88,15 @@ func luaColorRGB(l *lua.State) int {
 	return 1
 }
 
+// luaColorRGBA creates an RGB color with opacity: hobby.rgba(r, g, b, a)
+func luaColorRGBA(l *lua.State) int {
+	r := lua.CheckNumber(l, 1)
+	g := lua.CheckNumber(l, 2)
+	b := lua.CheckNumber(l, 3)
+	pushColor(l, mp.ColorRGBA(r, g, b, checkOpacity(l, 4)))
+	return 1
+}
+
 // luaColorGray creates a grayscale color: hobby.gray(g)
 func luaColorGray(l *lua.State) int {
 	g := lua.CheckNumber(l, 1)
@@ -83,8 +106,30 @@ func luaColorGray(l *lua.State) int {
 
 // luaColorCSS creates a color from CSS string: hobby.color("red")
 func luaColorCSS(l *lua.State) int {
-	css := lua.CheckString(l, 1)
-	pushColor(l, mp.ColorCSS(css))
+	pushColor(l, cssColor(lua.CheckString(l, 1)))
+	return 1
+}
+
+// cssColor parses a CSS color. mp.ColorCSS splits off the alpha of hex
+// colors; rgba() and rgb() with an alpha are split here, so that the
+// opacity is known for mixing and written as fill-opacity/stroke-opacity.
+func cssColor(css string) mp.Color {
+	c := mp.ColorCSS(css)
+	if _, has := c.Opacity(); has || !strings.HasPrefix(strings.ToLower(strings.TrimSpace(css)), "rgb") {
+		return c
+	}
+	r, g, b, a, ok := colorComponents(c)
+	if !ok || a == 1 {
+		return c
+	}
+	return mp.ColorRGBA(r, g, b, a)
+}
+
+// paintOpacity returns the opacity of c, 1 if it has none.
+func paintOpacity(c mp.Color) float64 {
+	if a, ok := colorOpacity(c); ok {
+		return a
+	}
 	return 1
 }
 
@@ -100,13 +145,142 @@ func pushColor(l *lua.State, c mp.Color) {
 
 func registerColorMeta(l *lua.State) {
 	lua.NewMetaTable(l, "hobby.color")
+	l.PushGoFunction(colorIndex)
+	l.SetField(-2, "__index")
 	l.PushGoFunction(colorToString)
 	l.SetField(-2, "__tostring")
+	l.PushGoFunction(colorEqual)
+	l.SetField(-2, "__eq")
+	l.PushGoFunction(colorAdd)
+	l.SetField(-2, "__add")
+	l.PushGoFunction(colorSub)
+	l.SetField(-2, "__sub")
+	l.PushGoFunction(colorMul)
+	l.SetField(-2, "__mul")
 	l.Pop(1)
 }
 
+// colorEqual compares two colors by their components, so that "red",
+// "#ff0000" and h.rgb(1, 0, 0) are equal. Colors that cannot be parsed
+// are compared by their CSS text.
+func colorEqual(l *lua.State) int {
+	a := l.ToUserData(1).(*colorWrapper).color
+	b := l.ToUserData(2).(*colorWrapper).color
+	ar, ag, ab, aa, aok := colorComponents(a)
+	br, bg, bb, ba, bok := colorComponents(b)
+	if aok && bok {
+		// CSS colors have 8 bit components: gray(0.5) equals rgb(128,128,128)
+		const eps = 0.75 / 255
+		l.PushBoolean(math.Abs(ar-br) < eps && math.Abs(ag-bg) < eps &&
+			math.Abs(ab-bb) < eps && math.Abs(aa-ba) < eps)
+		return 1
+	}
+	l.PushBoolean(a.CSS() == b.CSS())
+	return 1
+}
+
 func colorToString(l *lua.State) int {
 	cw := l.ToUserData(1).(*colorWrapper)
+	if g, ok := gradientOf(cw.color); ok {
+		l.PushString(gradientString(g))
+		return 1
+	}
+	if m, ok := modelColorOf(cw.color); ok {
+		l.PushString(modelColorString(m))
+		return 1
+	}
+	if pat, ok := patternOf(cw.color); ok {
+		l.PushString(patternString(pat))
+		return 1
+	}
+	if a, ok := opacityOnly(cw.color); ok {
+		l.PushString(fmt.Sprintf("opacity(%g)", a))
+		return 1
+	}
+	if a, ok := colorOpacity(cw.color); ok {
+		if r, g, b, _, ok := colorComponents(cw.color); ok {
+			l.PushString(fmt.Sprintf("rgba(%d,%d,%d,%g)",
+				int(math.Round(r*255)), int(math.Round(g*255)), int(math.Round(b*255)), a))
+			return 1
+		}
+	}
 	l.PushString(cw.color.CSS())
 	return 1
 }
+
+// colorComponents recovers the RGB components (0-1 range) and opacity of a
+// color. Understands the CSS forms produced by the mp color constructors:
+// rgb()/rgba() functions, #rgb/#rrggbb hex strings and CSS color names,
+// and the gray, CMYK and spot colors (through their RGB equivalent).
+// Returns ok=false for "none", unset colors and anything it cannot parse.
+func colorComponents(c mp.Color) (r, g, b, a float64, ok bool) {
+	a = 1
+	if m, isModel := modelColorOf(c); isModel {
+		if m.Alpha != nil {
+			a = *m.Alpha
+		}
+		r, g, b = m.rgb()
+		return r, g, b, a, true
+	}
+	if op, has := c.Opacity(); has {
+		a = op
+	}
+	css := strings.ToLower(strings.TrimSpace(c.CSS()))
+	switch {
+	case strings.HasPrefix(css, "#"):
+		hex := css[1:]
+		if len(hex) == 3 {
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		}
+		if len(hex) != 6 {
+			return 0, 0, 0, 0, false
+		}
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return 0, 0, 0, 0, false
+		}
+		r, g, b = splitRGB(uint32(v))
+		return r, g, b, a, true
+
+	case strings.HasPrefix(css, "rgb"):
+		open := strings.IndexByte(css, '(')
+		end := strings.IndexByte(css, ')')
+		if open < 0 || end < open {
+			return 0, 0, 0, 0, false
+		}
+		parts := strings.FieldsFunc(css[open+1:end], func(r rune) bool {
+			return r == ',' || r == ' ' || r == '/'
+		})
+		if len(parts) < 3 {
+			return 0, 0, 0, 0, false
+		}
+		var comp [4]float64
+		comp[3] = a
+		for i := 0; i < len(parts) && i < 4; i++ {
+			s := parts[i]
+			percent := strings.HasSuffix(s, "%")
+			v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+			if err != nil {
+				return 0, 0, 0, 0, false
+			}
+			switch {
+			case percent:
+				v /= 100
+			case i < 3:
+				v /= 255
+			}
+			comp[i] = v
+		}
+		return comp[0], comp[1], comp[2], comp[3], true
+	}
+	if v, found := cssColorNames[css]; found {
+		r, g, b = splitRGB(v)
+		return r, g, b, a, true
+	}
+	return 0, 0, 0, 0, false
+}
+
+// splitRGB converts a 0xRRGGBB value into components in the 0-1 range.
+func splitRGB(v uint32) (r, g, b float64) {
+	return float64(v>>16&0xff) / 255, float64(v>>8&0xff) / 255, float64(v&0xff) / 255
+}
@@ -0,0 +1,341 @@
+package hobby
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/boxesandglue/mpgo/mp"
+	lua "github.com/speedata/go-lua"
+)
+
+// colorModelPrefix marks colors in the gray, CMYK or spot color model.
+// mp.Color only knows CSS, so like gradients these colors are stored in
+// the color's CSS text. SVG, the only output so far, gets their RGB
+// equivalent. The model is kept in the color, where c.model,
+// c.components and the conversions see it, but no output writes it: that
+// needs a PDF or EPS backend, which hobby does not have.
+const colorModelPrefix = "hobby-color:"
+
+// modelColor is a color in a device color model or a spot color.
+type modelColor struct {
+	Model  string      // "gray", "cmyk", "spot" or "rgb" (only for alternates)
+	Values []float64   // gray: g; cmyk: c, m, y, k; spot: tint; rgb: r, g, b
+	Name   string      `json:",omitempty"` // name of a spot color
+	Alt    *modelColor `json:",omitempty"` // spot: the color at full tint
+	Alpha  *float64    `json:",omitempty"`
+}
+
+// color returns m encoded as a color.
+func (m *modelColor) color() mp.Color {
+	data, _ := json.Marshal(m)
+	return mp.ColorCSS(colorModelPrefix + string(data))
+}
+
+// modelColorOf decodes the model color stored in c. ok is false for CSS
+// colors and other paints.
+func modelColorOf(c mp.Color) (*modelColor, bool) {
+	css := c.CSS()
+	if !strings.HasPrefix(css, colorModelPrefix) {
+		return nil, false
+	}
+	m := &modelColor{}
+	if err := json.Unmarshal([]byte(css[len(colorModelPrefix):]), m); err != nil {
+		return nil, false
+	}
+	return m, true
+}
+
+// colorModel returns c as a model color. CSS colors are returned in the
+// RGB model; ok is false for paints that are not a single color.
+func colorModel(c mp.Color) (*modelColor, bool) {
+	if m, ok := modelColorOf(c); ok {
+		return m, true
+	}
+	r, g, b, _, ok := colorComponents(c)
+	if !ok {
+		return nil, false
+	}
+	m := &modelColor{Model: "rgb", Values: []float64{r, g, b}}
+	if a, has := c.Opacity(); has {
+		m.Alpha = &a
+	}
+	return m, true
+}
+
+// value returns component i of m, 0 if it is missing.
+func (m *modelColor) value(i int) float64 {
+	if i < len(m.Values) {
+		return m.Values[i]
+	}
+	return 0
+}
+
+// rgb converts m to RGB. CMYK is converted without a color profile, the
+// way PostScript does it.
+func (m *modelColor) rgb() (r, g, b float64) {
+	switch m.Model {
+	case "gray":
+		v := m.value(0)
+		return v, v, v
+	case "cmyk":
+		k := m.value(3)
+		return (1 - m.value(0)) * (1 - k), (1 - m.value(1)) * (1 - k), (1 - m.value(2)) * (1 - k)
+	case "spot":
+		if m.Alt == nil {
+			return 0, 0, 0
+		}
+		// a tint mixes the full color with white (the paper)
+		t := m.value(0)
+		r, g, b = m.Alt.rgb()
+		return 1 - t*(1-r), 1 - t*(1-g), 1 - t*(1-b)
+	}
+	return m.value(0), m.value(1), m.value(2)
+}
+
+// cmyk converts m to CMYK. RGB colors get as much black as possible.
+func (m *modelColor) cmyk() (c, mg, y, k float64) {
+	switch m.Model {
+	case "cmyk":
+		return m.value(0), m.value(1), m.value(2), m.value(3)
+	case "gray":
+		return 0, 0, 0, 1 - m.value(0)
+	case "spot":
+		if m.Alt != nil {
+			t := m.value(0)
+			c, mg, y, k = m.Alt.cmyk()
+			return c * t, mg * t, y * t, k * t
+		}
+	}
+	r, g, b := m.rgb()
+	k = 1 - math.Max(r, math.Max(g, b))
+	if k >= 1 {
+		return 0, 0, 0, 1
+	}
+	return (1 - r - k) / (1 - k), (1 - g - k) / (1 - k), (1 - b - k) / (1 - k), k
+}
+
+// gray converts m to a gray level with the weights PostScript uses.
+func (m *modelColor) gray() float64 {
+	if m.Model == "gray" {
+		return m.value(0)
+	}
+	if m.Model == "cmyk" {
+		c, mg, y, k := m.cmyk()
+		return 1 - math.Min(1, 0.3*c+0.59*mg+0.11*y+k)
+	}
+	r, g, b := m.rgb()
+	return 0.3*r + 0.59*g + 0.11*b
+}
+
+// css returns the RGB equivalent of m as used for SVG output.
+func (m *modelColor) css() mp.Color {
+	r, g, b := m.rgb()
+	if m.Alpha != nil {
+		return mp.ColorRGBA(r, g, b, *m.Alpha)
+	}
+	return mp.ColorRGB(r, g, b)
+}
+
+// colorOpacity is c.Opacity() for all colors, including model colors.
+func colorOpacity(c mp.Color) (float64, bool) {
+	if m, ok := modelColorOf(c); ok {
+		if m.Alpha == nil {
+			return 0, false
+		}
+		return *m.Alpha, true
+	}
+	return c.Opacity()
+}
+
+// displayColor returns the color used for display: the RGB equivalent
+// for model colors, c itself otherwise.
+func displayColor(c mp.Color) mp.Color {
+	if m, ok := modelColorOf(c); ok {
+		return m.css()
+	}
+	return c
+}
+
+// modelColorString describes a model color for tostring().
+func modelColorString(m *modelColor) string {
+	values := make([]string, len(m.Values))
+	for i, v := range m.Values {
+		values[i] = strconv.FormatFloat(v, 'g', 4, 64)
+	}
+	if m.Alpha != nil {
+		values = append(values, "alpha "+strconv.FormatFloat(*m.Alpha, 'g', 4, 64))
+	}
+	if m.Model == "spot" {
+		return fmt.Sprintf("spot(%q, %s)", m.Name, strings.Join(values, ", "))
+	}
+	return fmt.Sprintf("%s(%s)", m.Model, strings.Join(values, ", "))
+}
+
+// luaColorCMYK creates a CMYK color: hobby.cmyk(c, m, y, k)
+func luaColorCMYK(l *lua.State) int {
+	values := make([]float64, 4)
+	for i := range values {
+		values[i] = clamp01(lua.CheckNumber(l, i+1))
+	}
+	pushColor(l, (&modelColor{Model: "cmyk", Values: values}).color())
+	return 1
+}
+
+// luaColorSpot creates a spot color: hobby.spot(name, fallback[, tint])
+// fallback is the color at full tint (any color, e.g. h.cmyk(...)); it is
+// used wherever the spot color cannot be used directly.
+func luaColorSpot(l *lua.State) int {
+	name := lua.CheckString(l, 1)
+	alt, ok := colorModel(checkColor(l, 2))
+	if !ok || alt.Model == "spot" {
+		lua.Errorf(l, "spot: the fallback must be a process color")
+		return 0
+	}
+	alt.Alpha = nil
+	tint := clamp01(lua.OptNumber(l, 3, 1))
+	pushColor(l, (&modelColor{Model: "spot", Values: []float64{tint}, Name: name, Alt: alt}).color())
+	return 1
+}
+
+// clamp01 limits v to the range 0-1.
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+// colorIndex handles component access and method calls on colors.
+func colorIndex(l *lua.State) int {
+	c := l.ToUserData(1).(*colorWrapper).color
+	key, _ := l.ToString(2)
+
+	switch key {
+	case "model":
+		// c.model - "rgb", "gray", "cmyk", "spot", "gradient", "pattern"
+		// or "css" for colors that cannot be decomposed
+		l.PushString(colorModelName(c))
+		return 1
+
+	case "components":
+		// c:components() - the components in the color's model: r, g, b;
+		// gray; c, m, y, k; or the tint of a spot color
+		l.PushGoFunction(func(l *lua.State) int {
+			m, ok := colorModel(c)
+			if !ok {
+				return 0
+			}
+			for _, v := range m.Values {
+				l.PushNumber(v)
+			}
+			return len(m.Values)
+		})
+		return 1
+
+	case "torgb", "tocmyk", "togray":
+		// c:torgb(), c:tocmyk(), c:togray() - convert to another model; the
+		// opacity is kept
+		l.PushGoFunction(func(l *lua.State) int {
+			m, ok := colorModel(c)
+			if !ok {
+				lua.Errorf(l, "%s: not a color", key)
+				return 0
+			}
+			pushColor(l, convertColor(m, key[2:]))
+			return 1
+		})
+		return 1
+
+	case "r", "g", "b":
+		// c.r, c.g, c.b - the RGB components (0-1)
+		r, g, b, _, ok := colorComponents(c)
+		if !ok {
+			return 0
+		}
+		switch key {
+		case "r":
+			l.PushNumber(r)
+		case "g":
+			l.PushNumber(g)
+		default:
+			l.PushNumber(b)
+		}
+		return 1
+
+	case "a":
+		// c.a - the opacity, 1 for opaque colors
+		l.PushNumber(paintOpacity(c))
+		return 1
+
+	case "hsl", "hsv", "lab":
+		// c:hsl() - hue (degrees), saturation, lightness; c:hsv() - hue,
+		// saturation, value; c:lab() - CIE L*, a*, b*
+		l.PushGoFunction(func(l *lua.State) int {
+			r, g, b, _, ok := colorComponents(c)
+			if !ok {
+				lua.Errorf(l, "%s: not a color", key)
+				return 0
+			}
+			var x, y, z float64
+			switch key {
+			case "hsl":
+				x, y, z = rgbToHSL(r, g, b)
+			case "hsv":
+				x, y, z = rgbToHSV(r, g, b)
+			default:
+				x, y, z = rgbToLab(r, g, b)
+			}
+			l.PushNumber(x)
+			l.PushNumber(y)
+			l.PushNumber(z)
+			return 3
+		})
+		return 1
+
+	case "spotname":
+		// c.spotname - the name of a spot color, nil for other colors
+		if m, ok := modelColorOf(c); ok && m.Model == "spot" {
+			l.PushString(m.Name)
+			return 1
+		}
+		return 0
+	}
+	return 0
+}
+
+// convertColor converts m to the model "rgb", "cmyk" or "gray".
+func convertColor(m *modelColor, model string) mp.Color {
+	var result *modelColor
+	switch model {
+	case "rgb":
+		r, g, b := m.rgb()
+		if m.Alpha != nil {
+			return mp.ColorRGBA(r, g, b, *m.Alpha)
+		}
+		return mp.ColorRGB(r, g, b)
+	case "cmyk":
+		c, mg, y, k := m.cmyk()
+		result = &modelColor{Model: "cmyk", Values: []float64{c, mg, y, k}}
+	default:
+		result = &modelColor{Model: "gray", Values: []float64{m.gray()}}
+	}
+	result.Alpha = m.Alpha
+	return result.color()
+}
+
+// colorModelName returns the name of the color model of c.
+func colorModelName(c mp.Color) string {
+	if m, ok := modelColorOf(c); ok {
+		return m.Model
+	}
+	if _, ok := gradientOf(c); ok {
+		return "gradient"
+	}
+	if _, ok := patternOf(c); ok {
+		return "pattern"
+	}
+	if _, _, _, _, ok := colorComponents(c); ok {
+		return "rgb"
+	}
+	return "css"
+}
@@ -0,0 +1,314 @@
+package hobby
+
+import (
+	"math"
+
+	"github.com/boxesandglue/mpgo/mp"
+	lua "github.com/speedata/go-lua"
+)
+
+// Color arithmetic works like MetaPost's: on the components, with the
+// result clamped to 0-1. Gray and CMYK colors stay in their model when
+// combined with colors of the same model, as do tints of one spot color;
+// everything else is computed in RGB.
+
+// toRGB returns m in the RGB model.
+func (m *modelColor) toRGB() *modelColor {
+	r, g, b := m.rgb()
+	return &modelColor{Model: "rgb", Values: []float64{r, g, b}, Alpha: m.Alpha}
+}
+
+// plain returns m as a color. RGB colors become ordinary CSS colors.
+func (m *modelColor) plain() mp.Color {
+	if m.Model == "rgb" {
+		return m.css()
+	}
+	return m.color()
+}
+
+// commonModel returns a and b in a model they share.
+func commonModel(a, b *modelColor) (*modelColor, *modelColor) {
+	if a.Model == b.Model && (a.Model != "spot" || a.Name == b.Name) {
+		return a, b
+	}
+	return a.toRGB(), b.toRGB()
+}
+
+// combineColors applies f to the components of a and b. The result has
+// the opacity of a, or that of b if a has none.
+func combineColors(a, b *modelColor, f func(x, y float64) float64) *modelColor {
+	a, b = commonModel(a, b)
+	values := make([]float64, len(a.Values))
+	for i := range values {
+		values[i] = clamp01(f(a.value(i), b.value(i)))
+	}
+	result := &modelColor{Model: a.Model, Values: values, Name: a.Name, Alt: a.Alt, Alpha: a.Alpha}
+	if result.Alpha == nil {
+		result.Alpha = b.Alpha
+	}
+	return result
+}
+
+// scaleColor multiplies the components of m by s. For spot colors this
+// scales the tint.
+func scaleColor(m *modelColor, s float64) *modelColor {
+	result := *m
+	result.Values = make([]float64, len(m.Values))
+	for i, v := range m.Values {
+		result.Values[i] = clamp01(v * s)
+	}
+	return &result
+}
+
+// mixColors blends two colors: t=0 gives a, t=1 gives b, like MetaPost's
+// t[a,b]. Colors that cannot be decomposed switch over at t=0.5.
+func mixColors(a, b mp.Color, t float64) mp.Color {
+	ma, okA := colorModel(a)
+	mb, okB := colorModel(b)
+	if !okA || !okB {
+		if t < 0.5 {
+			return a
+		}
+		return b
+	}
+	lerp := func(x, y float64) float64 { return x + t*(y-x) }
+	result := combineColors(ma, mb, lerp)
+	if ma.Alpha != nil || mb.Alpha != nil {
+		alpha := lerp(paintOpacity(a), paintOpacity(b))
+		result.Alpha = &alpha
+	}
+	return result.plain()
+}
+
+// checkColorModel reads a color at index that can be used in arithmetic.
+func checkColorModel(l *lua.State, index int) *modelColor {
+	m, ok := colorModel(checkColor(l, index))
+	if !ok {
+		lua.Errorf(l, "color arithmetic needs plain colors, not gradients or patterns (argument %d)", index)
+		return nil
+	}
+	return m
+}
+
+// checkColorOperands reads the colors of c1 op c2; verb names op in the
+// message for operands that cannot be colors, such as numbers.
+func checkColorOperands(l *lua.State, verb string) (*modelColor, *modelColor) {
+	name := func(index int) string {
+		switch l.TypeOf(index) {
+		case lua.TypeUserData:
+			if _, ok := l.ToUserData(index).(*colorWrapper); ok {
+				return "a color"
+			}
+		case lua.TypeNil:
+			return "nil"
+		}
+		return "a " + lua.TypeNameOf(l, index)
+	}
+	for index := 1; index <= 2; index++ {
+		switch l.TypeOf(index) {
+		case lua.TypeUserData, lua.TypeString, lua.TypeTable:
+		default:
+			lua.Errorf(l, "cannot %s %s and %s", verb, name(1), name(2))
+		}
+	}
+	return checkColorModel(l, 1), checkColorModel(l, 2)
+}
+
+// colorAdd implements c1 + c2.
+func colorAdd(l *lua.State) int {
+	a, b := checkColorOperands(l, "add")
+	pushColor(l, combineColors(a, b, func(x, y float64) float64 { return x + y }).plain())
+	return 1
+}
+
+// colorSub implements c1 - c2.
+func colorSub(l *lua.State) int {
+	a, b := checkColorOperands(l, "subtract")
+	pushColor(l, combineColors(a, b, func(x, y float64) float64 { return x - y }).plain())
+	return 1
+}
+
+// colorMul implements s * c and c * s.
+func colorMul(l *lua.State) int {
+	c, s := 1, 2
+	if l.IsNumber(1) {
+		c, s = 2, 1
+	}
+	m := checkColorModel(l, c)
+	pushColor(l, scaleColor(m, lua.CheckNumber(l, s)).plain())
+	return 1
+}
+
+// luaMix blends two colors: hobby.mix(c1, c2, t), MetaPost's t[c1,c2]
+func luaMix(l *lua.State) int {
+	a := checkColor(l, 1)
+	b := checkColor(l, 2)
+	pushColor(l, mixColors(a, b, lua.CheckNumber(l, 3)))
+	return 1
+}
+
+// luaColorHSL creates a color from hue (degrees), saturation and
+// lightness: hobby.hsl(h, s, l[, a])
+func luaColorHSL(l *lua.State) int {
+	r, g, b := hslToRGB(lua.CheckNumber(l, 1), lua.CheckNumber(l, 2), lua.CheckNumber(l, 3))
+	pushColor(l, rgbWithAlpha(l, r, g, b, 4))
+	return 1
+}
+
+// luaColorHSV creates a color from hue (degrees), saturation and value:
+// hobby.hsv(h, s, v[, a])
+func luaColorHSV(l *lua.State) int {
+	r, g, b := hsvToRGB(lua.CheckNumber(l, 1), lua.CheckNumber(l, 2), lua.CheckNumber(l, 3))
+	pushColor(l, rgbWithAlpha(l, r, g, b, 4))
+	return 1
+}
+
+// luaColorLab creates a color from CIE L*a*b* coordinates (D65 white,
+// L from 0 to 100): hobby.lab(L, a, b[, alpha]). Colors outside the sRGB
+// gamut are clamped.
+func luaColorLab(l *lua.State) int {
+	r, g, b := labToRGB(lua.CheckNumber(l, 1), lua.CheckNumber(l, 2), lua.CheckNumber(l, 3))
+	pushColor(l, rgbWithAlpha(l, r, g, b, 4))
+	return 1
+}
+
+// rgbWithAlpha returns an RGB color with the optional opacity at index.
+func rgbWithAlpha(l *lua.State, r, g, b float64, index int) mp.Color {
+	if l.IsNoneOrNil(index) {
+		return mp.ColorRGB(r, g, b)
+	}
+	return mp.ColorRGBA(r, g, b, checkOpacity(l, index))
+}
+
+// hslToRGB converts hue (degrees), saturation and lightness to RGB.
+func hslToRGB(h, s, light float64) (r, g, b float64) {
+	s, light = clamp01(s), clamp01(light)
+	c := (1 - math.Abs(2*light-1)) * s
+	return hueToRGB(h, c, light-c/2)
+}
+
+// hsvToRGB converts hue (degrees), saturation and value to RGB.
+func hsvToRGB(h, s, v float64) (r, g, b float64) {
+	s, v = clamp01(s), clamp01(v)
+	c := v * s
+	return hueToRGB(h, c, v-c)
+}
+
+// hueToRGB returns the RGB color with hue h (degrees) and chroma c,
+// lifted by m.
+func hueToRGB(h, c, m float64) (r, g, b float64) {
+	h = math.Mod(h, 360)
+	if h < 0 {
+		h += 360
+	}
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	return r + m, g + m, b + m
+}
+
+// rgbToHue returns the hue (degrees), chroma and maximum component of an
+// RGB color.
+func rgbToHue(r, g, b float64) (h, c, max float64) {
+	max = math.Max(r, math.Max(g, b))
+	min := math.Min(r, math.Min(g, b))
+	c = max - min
+	switch {
+	case c == 0:
+		h = 0
+	case max == r:
+		h = 60 * math.Mod((g-b)/c, 6)
+	case max == g:
+		h = 60 * ((b-r)/c + 2)
+	default:
+		h = 60 * ((r-g)/c + 4)
+	}
+	if h < 0 {
+		h += 360
+	}
+	return h, c, max
+}
+
+// rgbToHSL converts RGB to hue (degrees), saturation and lightness.
+func rgbToHSL(r, g, b float64) (h, s, light float64) {
+	h, c, max := rgbToHue(r, g, b)
+	light = max - c/2
+	if light > 0 && light < 1 {
+		s = c / (1 - math.Abs(2*light-1))
+	}
+	return h, s, light
+}
+
+// rgbToHSV converts RGB to hue (degrees), saturation and value.
+func rgbToHSV(r, g, b float64) (h, s, v float64) {
+	h, c, max := rgbToHue(r, g, b)
+	if max > 0 {
+		s = c / max
+	}
+	return h, s, max
+}
+
+// D65 reference white of the CIE L*a*b* conversions.
+const whiteX, whiteY, whiteZ = 0.95047, 1.0, 1.08883
+
+// rgbToLab converts sRGB to CIE L*a*b*.
+func rgbToLab(r, g, b float64) (light, a, bb float64) {
+	r, g, b = srgbToLinear(r), srgbToLinear(g), srgbToLinear(b)
+	x := (0.4124564*r + 0.3575761*g + 0.1804375*b) / whiteX
+	y := (0.2126729*r + 0.7151522*g + 0.0721750*b) / whiteY
+	z := (0.0193339*r + 0.1191920*g + 0.9503041*b) / whiteZ
+	f := func(t float64) float64 {
+		if t > 216.0/24389 {
+			return math.Cbrt(t)
+		}
+		return (24389.0/27*t + 16) / 116
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+// labToRGB converts CIE L*a*b* to sRGB, clamped to the gamut.
+func labToRGB(light, a, bb float64) (r, g, b float64) {
+	fy := (light + 16) / 116
+	fx := fy + a/500
+	fz := fy - bb/200
+	finv := func(t float64) float64 {
+		if t*t*t > 216.0/24389 {
+			return t * t * t
+		}
+		return (116*t - 16) * 27 / 24389
+	}
+	x, y, z := finv(fx)*whiteX, finv(fy)*whiteY, finv(fz)*whiteZ
+	return linearToSRGB(3.2404542*x - 1.5371385*y - 0.4985314*z),
+		linearToSRGB(-0.9692660*x + 1.8760108*y + 0.0415560*z),
+		linearToSRGB(0.0556434*x - 0.2040259*y + 1.0572252*z)
+}
+
+// srgbToLinear removes the sRGB gamma from a component.
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// linearToSRGB applies the sRGB gamma to a linear component, clamped to
+// 0-1.
+func linearToSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return clamp01(12.92 * v)
+	}
+	return clamp01(1.055*math.Pow(v, 1/2.4) - 0.055)
+}
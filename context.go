@@ -1,15 +1,357 @@
 package hobby
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/boxesandglue/mpgo/draw"
 	"github.com/boxesandglue/mpgo/mp"
 	lua "github.com/speedata/go-lua"
 )
 
+// solverContext is the equation context behind h.context(). Point
+// variables are draw.Vars, so that paths built with ctx:path() can refer
+// to them; their coordinates are unknowns of the solver and are written
+// to the draw.Var as soon as they are known.
+//
+// Every constraint call is kept in ops, so that the system can be solved
+// again after a placed point was moved (ctx:drag) or constraints were
+// dropped (ctx:restore) without building a new context.
+type solverContext struct {
+	ctx          *draw.Context
+	solver       solver
+	points       []*pointVar
+	numerics     []*numericVar
+	names        []string         // names of the unknowns, for reports
+	anonymous    int              // number of anonymous unknowns
+	whatevers    int              // number of ctx:whatever() unknowns
+	records      []equationRecord // all equations given so far
+	ops          []solverOp       // all constraint calls given so far
+	nonlinear    []nonlinearConstraint
+	inequalities []inequality
+	objective    []objectiveTerm // minimized together
+	guesses      map[int]float64 // start values for the nonlinear solver
+	calls        int             // number of constraint calls
+	call         equationCall    // the constraint call being processed
+}
+
+// solverOp is a constraint call. run adds its equations to the solver,
+// when the call is made and again when the system is replayed.
+type solverOp struct {
+	call equationCall
+	run  func(op *solverOp) error
+	// pin is the point placed by ctx:known() or v:setxy() at x and y
+	// (nil if not given), which ctx:drag() can move.
+	pin  *pointVar
+	x, y *float64
+}
+
+// contextSnapshot is the state saved by ctx:snapshot().
+type contextSnapshot struct {
+	ctx   *solverContext
+	ops   []solverOp
+	calls int
+}
+
+// pointVar is a point variable of a context. Its x coordinate is unknown
+// x of the solver, its y coordinate unknown x+1.
+type pointVar struct {
+	ctx  *solverContext
+	v    *draw.Var
+	x    int
+	name string
+}
+
+// numericVar is a scalar variable of a context.
+type numericVar struct {
+	ctx   *solverContext
+	index int
+	name  string
+}
+
+// equationCall identifies the call of a context method that adds
+// equations.
+type equationCall struct {
+	n     int    // the calls are numbered from 1
+	kind  string // the method, e.g. "eq" or "midpoint"
+	where string // the position in the Lua script
+}
+
+// equationRecord is one equation and what it contributed. Point
+// constraints give an equation for each coordinate.
+type equationRecord struct {
+	equationCall
+	part   string // "x", "y" or "" for numeric equations
+	status equationStatus
+	off    float64 // for inconsistent equations: the amount of violation
+}
+
+// newPoint adds an unknown point variable. An empty name is replaced by
+// "point" and its number.
+func (c *solverContext) newPoint(name string) *pointVar {
+	if name == "" {
+		name = fmt.Sprintf("point%d", len(c.points)+1)
+	}
+	p := &pointVar{ctx: c, v: c.ctx.Unknown(), x: c.newVar(name + ".x"), name: name}
+	c.newVar(name + ".y")
+	c.points = append(c.points, p)
+	return p
+}
+
+// newNumeric adds an unknown numeric variable. An empty name is replaced
+// by "numeric" and its number.
+func (c *solverContext) newNumeric(name string) *numericVar {
+	if name == "" {
+		name = fmt.Sprintf("numeric%d", len(c.numerics)+1)
+	}
+	n := &numericVar{ctx: c, index: c.newVar(name), name: name}
+	c.numerics = append(c.numerics, n)
+	return n
+}
+
+// newVar adds an unknown with the given name to the solver.
+func (c *solverContext) newVar(name string) int {
+	c.names = append(c.names, name)
+	return c.solver.newVar()
+}
+
+// whatever adds an anonymous unknown like MetaPost's whatever. It is not
+// a variable of the context, so it does not have to become known.
+func (c *solverContext) whatever() int {
+	c.whatevers++
+	return c.newVar(fmt.Sprintf("whatever%d", c.whatevers))
+}
+
+// begin starts a constraint call of the method kind, so that its
+// equations can be traced back to the script.
+func (c *solverContext) begin(l *lua.State, kind string) {
+	c.calls++
+	lua.Where(l, 1)
+	where, _ := l.ToString(-1)
+	l.Pop(1)
+	c.call = equationCall{n: c.calls, kind: kind, where: strings.TrimSuffix(where, ": ")}
+}
+
+// equate adds the equation e = 0 for the coordinate part of the current
+// call.
+func (c *solverContext) equate(e *linExpr, part string) {
+	status, off := c.solver.equate(e)
+	c.records = append(c.records, equationRecord{equationCall: c.call, part: part, status: status, off: off})
+	c.update()
+}
+
+// assign makes unknown i known with value v. The old value of an
+// independent unknown lives on as the anonymous unknown *anon, which is
+// created on the first call and reused when the call is replayed.
+func (c *solverContext) assign(i int, v float64, anon *int) {
+	if a := c.solver.assign(i, v, *anon); a >= 0 {
+		*anon = a
+	}
+	for len(c.names) < len(c.solver.deps) {
+		c.anonymous++
+		c.names = append(c.names, fmt.Sprintf("anonymous%d", c.anonymous))
+	}
+	c.update()
+}
+
+// set makes unknown i equal to v: while i is unknown this is an equation,
+// so variables depending on it follow; a known value is replaced.
+func (c *solverContext) set(i int, v float64, part string, anon *int) {
+	if _, known := c.solver.value(i); known {
+		c.assign(i, v, anon)
+		return
+	}
+	e := newLinExpr(-v)
+	e.addTerm(i, 1)
+	c.equate(e, part)
+}
+
+// update writes the coordinates to the point variables, 0 for the
+// unknown ones.
+func (c *solverContext) update() {
+	for _, p := range c.points {
+		x, _ := c.solver.value(p.x)
+		y, _ := c.solver.value(p.x + 1)
+		p.v.SetXY(x, y)
+	}
+}
+
+// constrain makes a constraint call of the method kind and keeps it for
+// replaying. A call that fails is not kept.
+func (c *solverContext) constrain(l *lua.State, kind string, op solverOp) error {
+	c.begin(l, kind)
+	op.call = c.call
+	if err := op.run(&op); err != nil {
+		return err
+	}
+	c.ops = append(c.ops, op)
+	return nil
+}
+
+// add makes a constraint call that cannot fail.
+func (c *solverContext) add(l *lua.State, kind string, run func()) {
+	c.constrain(l, kind, solverOp{run: func(*solverOp) error {
+		run()
+		return nil
+	}})
+}
+
+// place sets the coordinates of p that are given (x or y may be nil) as
+// a constraint call that ctx:drag() can change later.
+func (c *solverContext) place(l *lua.State, kind string, p *pointVar, x, y *float64) {
+	anonX, anonY := -1, -1
+	c.constrain(l, kind, solverOp{pin: p, x: x, y: y, run: func(op *solverOp) error {
+		if op.x != nil {
+			c.set(p.x, *op.x, "x", &anonX)
+		}
+		if op.y != nil {
+			c.set(p.x+1, *op.y, "y", &anonY)
+		}
+		return nil
+	}})
+}
+
+// replay solves the system again from the kept constraint calls.
+func (c *solverContext) replay() error {
+	c.solver.reset()
+	c.records = nil
+	c.nonlinear = nil
+	c.inequalities = nil
+	c.objective = nil
+	defer c.update()
+	for i := range c.ops {
+		op := &c.ops[i]
+		c.call = op.call
+		if err := op.run(op); err != nil {
+			return fmt.Errorf("%s at %s: %s", op.call.kind, op.call.where, err.Error())
+		}
+	}
+	if len(c.nonlinear) > 0 {
+		_, coupled, _ := c.splitInequalities()
+		if err := c.settle(coupled); err != nil {
+			return err
+		}
+	}
+	// again, as the unknowns fixed by settle are constants now
+	linear, _, source := c.splitInequalities()
+	if err := c.checkObjective(source); err != nil {
+		return err
+	}
+	if len(linear) > 0 || len(c.objective) > 0 {
+		return c.optimize(linear)
+	}
+	return nil
+}
+
+// drag moves the point p, which must have been placed by ctx:known() or
+// v:setxy(), to (x, y) and solves the system again.
+func (c *solverContext) drag(p *pointVar, x, y float64) error {
+	var setX, setY bool
+	for i := len(c.ops) - 1; i >= 0 && !(setX && setY); i-- {
+		op := &c.ops[i]
+		if op.pin != p {
+			continue
+		}
+		// new values instead of changing the old ones, which snapshots
+		// share
+		if op.x != nil && !setX {
+			op.x, setX = &x, true
+		}
+		if op.y != nil && !setY {
+			op.y, setY = &y, true
+		}
+	}
+	if !setX && !setY {
+		return fmt.Errorf("%s was not placed with known or setxy", p.name)
+	}
+	return c.replay()
+}
+
+// snapshot saves the constraint calls given so far.
+func (c *solverContext) snapshot() *contextSnapshot {
+	return &contextSnapshot{ctx: c, ops: append([]solverOp(nil), c.ops...), calls: c.calls}
+}
+
+// restore drops the constraint calls given after the snapshot s was taken
+// and solves the system again. Variables created since then stay, but
+// are unknown unless the saved constraints determine them.
+func (c *solverContext) restore(s *contextSnapshot) error {
+	c.ops = append([]solverOp(nil), s.ops...)
+	c.calls = s.calls
+	return c.replay()
+}
+
+// pairTerm is coef times a point variable.
+type pairTerm struct {
+	coef float64
+	p    *pointVar
+}
+
+// equatePairs adds the equation sum(terms) = rhs for both coordinates.
+func (c *solverContext) equatePairs(rhs mp.Point, terms ...pairTerm) {
+	ex, ey := newLinExpr(-rhs.X), newLinExpr(-rhs.Y)
+	for _, t := range terms {
+		ex.addTerm(t.p.x, t.coef)
+		ey.addTerm(t.p.x+1, t.coef)
+	}
+	c.equate(ex, "x")
+	c.equate(ey, "y")
+}
+
+// knownPoint returns the value of p; ok is false unless both coordinates
+// are known.
+func (c *solverContext) knownPoint(p *pointVar) (mp.Point, bool) {
+	x, okX := c.solver.value(p.x)
+	y, okY := c.solver.value(p.x + 1)
+	return mp.P(x, y), okX && okY
+}
+
+// collinear constrains p to the line through a and b. The equation
+// (p - a) x (b - a) = 0 is linear only if b - a is known.
+func (c *solverContext) collinear(p, a, b *pointVar) {
+	dx, okX := c.solver.valueOf(pairDiff(b, a, 0))
+	dy, okY := c.solver.valueOf(pairDiff(b, a, 1))
+	if !okX || !okY {
+		return
+	}
+	e := newLinExpr(0)
+	e.addTerm(p.x, dy)
+	e.addTerm(a.x, -dy)
+	e.addTerm(p.x+1, -dx)
+	e.addTerm(a.x+1, dx)
+	c.equate(e, "")
+}
+
+// pairDiff returns coordinate comp (0 for x, 1 for y) of a - b.
+func pairDiff(a, b *pointVar, comp int) *linExpr {
+	e := newLinExpr(0)
+	e.addTerm(a.x+comp, 1)
+	e.addTerm(b.x+comp, -1)
+	return e
+}
+
+// intersection constrains p to the intersection of the lines a1--a2 and
+// b1--b2, whose points must be known.
+func (c *solverContext) intersection(p, a1, a2, b1, b2 *pointVar) error {
+	var pts [4]mp.Point
+	for i, v := range []*pointVar{a1, a2, b1, b2} {
+		pt, ok := c.knownPoint(v)
+		if !ok {
+			return fmt.Errorf("Intersection requires all line endpoints to be known")
+		}
+		pts[i] = pt
+	}
+	pt, ok := mp.LineIntersection(pts[0], pts[1], pts[2], pts[3])
+	if !ok {
+		return fmt.Errorf("lines are parallel, no intersection")
+	}
+	c.equatePairs(pt, pairTerm{1, p})
+	return nil
+}
+
 // luaNewContext creates a new equation-solving context: h.context()
 func luaNewContext(l *lua.State) int {
-	ctx := draw.NewContext()
-	pushContext(l, ctx)
+	pushContext(l, &solverContext{ctx: draw.NewContext(), guesses: map[int]float64{}})
 	return 1
 }
 
@@ -19,170 +361,326 @@ func registerContextMeta(l *lua.State) {
 	l.PushGoFunction(contextIndex)
 	l.SetField(-2, "__index")
 	l.Pop(1)
+
+	lua.NewMetaTable(l, "hobby.snapshot")
+	l.Pop(1)
 }
 
-// registerVarMeta registers the metatable for Var (point variables)
+// registerVarMeta registers the metatables for Var (point variables) and
+// numeric variables
 func registerVarMeta(l *lua.State) {
 	lua.NewMetaTable(l, "hobby.var")
 	l.PushGoFunction(varIndex)
 	l.SetField(-2, "__index")
+	setExprArith(l)
+	l.Pop(1)
+
+	lua.NewMetaTable(l, "hobby.numeric")
+	l.PushGoFunction(numericIndex)
+	l.SetField(-2, "__index")
+	setExprArith(l)
 	l.Pop(1)
 }
 
 // pushContext pushes a Context as userdata
-func pushContext(l *lua.State, ctx *draw.Context) {
-	l.PushUserData(ctx)
+func pushContext(l *lua.State, c *solverContext) {
+	l.PushUserData(c)
 	lua.SetMetaTableNamed(l, "hobby.context")
 }
 
 // checkContext checks if value at index is a Context
-func checkContext(l *lua.State, index int) *draw.Context {
+func checkContext(l *lua.State, index int) *solverContext {
 	ud := l.ToUserData(index)
-	if ctx, ok := ud.(*draw.Context); ok {
-		return ctx
+	if c, ok := ud.(*solverContext); ok {
+		return c
 	}
 	lua.Errorf(l, "expected context at argument %d", index)
 	return nil
 }
 
 // pushVar pushes a Var as userdata
-func pushVar(l *lua.State, v *draw.Var) {
-	l.PushUserData(v)
+func pushVar(l *lua.State, p *pointVar) {
+	l.PushUserData(p)
 	lua.SetMetaTableNamed(l, "hobby.var")
 }
 
 // checkVar checks if value at index is a Var
-func checkVar(l *lua.State, index int) *draw.Var {
+func checkVar(l *lua.State, index int) *pointVar {
 	ud := l.ToUserData(index)
-	if v, ok := ud.(*draw.Var); ok {
-		return v
+	if p, ok := ud.(*pointVar); ok {
+		return p
 	}
 	lua.Errorf(l, "expected var at argument %d", index)
 	return nil
 }
 
+// checkCtxVar checks if value at index is a Var of the context c
+func checkCtxVar(l *lua.State, c *solverContext, index int) *pointVar {
+	p := checkVar(l, index)
+	if p.ctx != c {
+		lua.Errorf(l, "var at argument %d belongs to another context", index)
+		return nil
+	}
+	return p
+}
+
+// pushNumeric pushes a numeric variable as userdata
+func pushNumeric(l *lua.State, n *numericVar) {
+	l.PushUserData(n)
+	lua.SetMetaTableNamed(l, "hobby.numeric")
+}
+
+// checkLinearTerms reads the terms of ctx:linear() at index into e: a list
+// of {coef, var, "x"|"y"} for point variables and {coef, numeric} for
+// numeric variables.
+func checkLinearTerms(l *lua.State, c *solverContext, index int, e *linExpr) {
+	lua.CheckType(l, index, lua.TypeTable)
+	n := l.RawLength(index)
+	for i := 1; i <= n; i++ {
+		l.RawGetInt(index, i)
+		if !l.IsTable(-1) {
+			lua.Errorf(l, "linear: term %d must be a table {coef, var[, \"x\"|\"y\"]}", i)
+			return
+		}
+		l.RawGetInt(-1, 1)
+		coef, ok := l.ToNumber(-1)
+		l.Pop(1)
+		if !ok {
+			lua.Errorf(l, "linear: term %d needs a coefficient", i)
+			return
+		}
+		l.RawGetInt(-1, 2)
+		switch v := l.ToUserData(-1).(type) {
+		case *numericVar:
+			if v.ctx != c {
+				lua.Errorf(l, "linear: term %d belongs to another context", i)
+				return
+			}
+			e.addTerm(v.index, coef)
+		case *pointVar:
+			if v.ctx != c {
+				lua.Errorf(l, "linear: term %d belongs to another context", i)
+				return
+			}
+			l.RawGetInt(-2, 3)
+			comp, _ := l.ToString(-1)
+			l.Pop(1)
+			switch comp {
+			case "x":
+				e.addTerm(v.x, coef)
+			case "y":
+				e.addTerm(v.x+1, coef)
+			default:
+				lua.Errorf(l, "linear: term %d needs \"x\" or \"y\" for a point variable", i)
+				return
+			}
+		default:
+			lua.Errorf(l, "linear: term %d needs a variable", i)
+			return
+		}
+		l.Pop(2)
+	}
+}
+
 func contextIndex(l *lua.State) int {
-	ctx := checkContext(l, 1)
+	c := checkContext(l, 1)
 	key := lua.CheckString(l, 2)
 
 	switch key {
-	case "unknown":
-		// ctx:unknown() - create unknown point variable
+	case "unknown", "point":
+		// ctx:unknown([name]) - create unknown point variable; ctx:point() is
+		// an alias. The name is used in reports.
 		l.PushGoFunction(func(l *lua.State) int {
-			v := ctx.Unknown()
-			pushVar(l, v)
+			pushVar(l, c.newPoint(lua.OptString(l, 2, "")))
 			return 1
 		})
 		return 1
 
 	case "known":
-		// ctx:known(x, y) - create known point variable
+		// ctx:known(x, y[, name]) - create known point variable, which
+		// ctx:drag() can move
 		l.PushGoFunction(func(l *lua.State) int {
 			x := lua.CheckNumber(l, 2)
 			y := lua.CheckNumber(l, 3)
-			v := ctx.Known(x, y)
-			pushVar(l, v)
+			p := c.newPoint(lua.OptString(l, 4, ""))
+			c.place(l, key, p, &x, &y)
+			pushVar(l, p)
 			return 1
 		})
 		return 1
 
-	case "point":
-		// ctx:point() - alias for unknown
+	case "points":
+		// ctx:points(n[, prefix]) - create n unknown points, returns table;
+		// with a prefix they are named prefix1, prefix2, ...
 		l.PushGoFunction(func(l *lua.State) int {
-			v := ctx.Point()
-			pushVar(l, v)
+			n := lua.CheckInteger(l, 2)
+			prefix := lua.OptString(l, 3, "")
+			l.CreateTable(n, 0)
+			for i := 1; i <= n; i++ {
+				name := ""
+				if prefix != "" {
+					name = fmt.Sprintf("%s%d", prefix, i)
+				}
+				pushVar(l, c.newPoint(name))
+				l.RawSetInt(-2, i)
+			}
 			return 1
 		})
 		return 1
 
-	case "points":
-		// ctx:points(n) - create n unknown points, returns table
+	case "numeric":
+		// ctx:numeric([value][, name]) - create numeric variable, unknown
+		// unless a value is given; ctx:numeric(name) names an unknown one
 		l.PushGoFunction(func(l *lua.State) int {
-			n := lua.CheckInteger(l, 2)
-			vars := ctx.Points(n)
-			l.CreateTable(len(vars), 0)
-			for i, v := range vars {
-				pushVar(l, v)
-				l.RawSetInt(-2, i+1)
+			value, name := 2, 3
+			if l.TypeOf(2) == lua.TypeString {
+				value, name = 0, 2
+			}
+			n := c.newNumeric(lua.OptString(l, name, ""))
+			if value > 0 && !l.IsNoneOrNil(value) {
+				v, anon := lua.CheckNumber(l, value), -1
+				c.add(l, key, func() {
+					c.set(n.index, v, "", &anon)
+				})
 			}
+			pushNumeric(l, n)
 			return 1
 		})
 		return 1
 
-	case "eq":
-		// ctx:eq(v, point) - constrain v to equal point
+	case "whatever":
+		// ctx:whatever(["pair"]) - a fresh anonymous unknown for one
+		// equation, e.g. ctx:eq(z, ctx:whatever()*(z2 - z1) + z1); it does
+		// not have to become known
 		l.PushGoFunction(func(l *lua.State) int {
-			v := checkVar(l, 2)
-			p := checkPoint(l, 3)
-			ctx.Eq(v, p)
-			l.PushValue(1)
+			e := &solverExpr{ctx: c, x: newLinExpr(0)}
+			e.x.addTerm(c.whatever(), 1)
+			switch kind := lua.OptString(l, 2, "numeric"); kind {
+			case "numeric":
+			case "pair":
+				e.pair = true
+				e.y = newLinExpr(0)
+				e.y.addTerm(c.whatever(), 1)
+			default:
+				lua.Errorf(l, "whatever: unknown kind %s (use numeric or pair)", kind)
+				return 0
+			}
+			pushExpr(l, e)
 			return 1
 		})
 		return 1
 
-	case "eqx":
-		// ctx:eqx(v, x) - constrain v.x to value
+	case "linear":
+		// ctx:linear({{coef, var, "x"}, {coef, numeric}, ...}, constant) -
+		// constrain the sum of the terms to equal constant
 		l.PushGoFunction(func(l *lua.State) int {
-			v := checkVar(l, 2)
-			x := lua.CheckNumber(l, 3)
-			ctx.EqX(v, x)
+			e := newLinExpr(-lua.OptNumber(l, 3, 0))
+			checkLinearTerms(l, c, 2, e)
+			c.add(l, key, func() {
+				c.equate(e, "")
+			})
 			l.PushValue(1)
 			return 1
 		})
 		return 1
 
-	case "eqy":
-		// ctx:eqy(v, y) - constrain v.y to value
+	case "eq":
+		// ctx:eq(a, b) - constrain a = b; a and b are variables, points,
+		// numbers or expressions like z1 + z2 or 2*z3
 		l.PushGoFunction(func(l *lua.State) int {
-			v := checkVar(l, 2)
-			y := lua.CheckNumber(l, 3)
-			ctx.EqY(v, y)
+			a := checkExpr(l, 2)
+			b := checkExpr(l, 3)
+			if ctx := exprContext(l, a, b); ctx != nil && ctx != c {
+				lua.Errorf(l, "eq: the variables belong to another context")
+				return 0
+			}
+			if a.pair != b.pair {
+				lua.Errorf(l, "eq: cannot equate a pair and a numeric")
+				return 0
+			}
+			// the expressions are formed again when the system is replayed
+			err := c.constrain(l, key, solverOp{run: func(*solverOp) error {
+				diff, err := linear(c, a, b, 1, -1).refresh()
+				if err != nil {
+					return err
+				}
+				if diff.pair {
+					c.equate(diff.x, "x")
+					c.equate(diff.y, "y")
+				} else {
+					c.equate(diff.x, "")
+				}
+				return nil
+			}})
+			if err != nil {
+				lua.Errorf(l, "eq error: %s", err.Error())
+				return 0
+			}
 			l.PushValue(1)
 			return 1
 		})
 		return 1
 
-	case "linearxy":
-		// ctx:linearxy(v, cx, cy, constant) - constrain cx*v.x + cy*v.y = constant
+	case "eqx", "eqy":
+		// ctx:eqx(v, x), ctx:eqy(v, y) - constrain v.x or v.y to value
 		l.PushGoFunction(func(l *lua.State) int {
-			v := checkVar(l, 2)
-			cx := lua.CheckNumber(l, 3)
-			cy := lua.CheckNumber(l, 4)
-			constant := lua.CheckNumber(l, 5)
-			ctx.LinearXY(v, cx, cy, constant)
+			v := checkCtxVar(l, c, 2)
+			e := newLinExpr(-lua.CheckNumber(l, 3))
+			part := "x"
+			if key == "eqx" {
+				e.addTerm(v.x, 1)
+			} else {
+				e.addTerm(v.x+1, 1)
+				part = "y"
+			}
+			c.add(l, key, func() {
+				c.equate(e, part)
+			})
 			l.PushValue(1)
 			return 1
 		})
 		return 1
 
-	case "eqvar":
-		// ctx:eqvar(a, b) - constrain a = b
+	case "linearxy":
+		// ctx:linearxy(v, cx, cy, constant) - constrain cx*v.x + cy*v.y = constant
 		l.PushGoFunction(func(l *lua.State) int {
-			a := checkVar(l, 2)
-			b := checkVar(l, 3)
-			ctx.EqVar(a, b)
+			v := checkCtxVar(l, c, 2)
+			e := newLinExpr(-lua.CheckNumber(l, 5))
+			e.addTerm(v.x, lua.CheckNumber(l, 3))
+			e.addTerm(v.x+1, lua.CheckNumber(l, 4))
+			c.add(l, key, func() {
+				c.equate(e, "")
+			})
 			l.PushValue(1)
 			return 1
 		})
 		return 1
 
-	case "eqvarx":
-		// ctx:eqvarx(a, b) - constrain a.x = b.x
+	case "eqvar":
+		// ctx:eqvar(a, b) - constrain a = b
 		l.PushGoFunction(func(l *lua.State) int {
-			a := checkVar(l, 2)
-			b := checkVar(l, 3)
-			ctx.EqVarX(a, b)
+			a := checkCtxVar(l, c, 2)
+			b := checkCtxVar(l, c, 3)
+			c.add(l, key, func() {
+				c.equatePairs(mp.P(0, 0), pairTerm{1, a}, pairTerm{-1, b})
+			})
 			l.PushValue(1)
 			return 1
 		})
 		return 1
 
-	case "eqvary":
-		// ctx:eqvary(a, b) - constrain a.y = b.y
+	case "eqvarx", "eqvary":
+		// ctx:eqvarx(a, b), ctx:eqvary(a, b) - constrain a.x = b.x or a.y = b.y
 		l.PushGoFunction(func(l *lua.State) int {
-			a := checkVar(l, 2)
-			b := checkVar(l, 3)
-			ctx.EqVarY(a, b)
+			a := checkCtxVar(l, c, 2)
+			b := checkCtxVar(l, c, 3)
+			comp, part := 0, "x"
+			if key == "eqvary" {
+				comp, part = 1, "y"
+			}
+			c.add(l, key, func() {
+				c.equate(pairDiff(a, b, comp), part)
+			})
 			l.PushValue(1)
 			return 1
 		})
@@ -191,10 +689,12 @@ func contextIndex(l *lua.State) int {
 	case "midpoint":
 		// ctx:midpoint(m, a, b) - constrain m = midpoint of a and b
 		l.PushGoFunction(func(l *lua.State) int {
-			m := checkVar(l, 2)
-			a := checkVar(l, 3)
-			b := checkVar(l, 4)
-			ctx.MidPoint(m, a, b)
+			m := checkCtxVar(l, c, 2)
+			a := checkCtxVar(l, c, 3)
+			b := checkCtxVar(l, c, 4)
+			c.add(l, key, func() {
+				c.equatePairs(mp.P(0, 0), pairTerm{1, m}, pairTerm{-0.5, a}, pairTerm{-0.5, b})
+			})
 			l.PushValue(1)
 			return 1
 		})
@@ -203,9 +703,12 @@ func contextIndex(l *lua.State) int {
 	case "midpointof":
 		// ctx:midpointof(a, b) - returns new var at midpoint
 		l.PushGoFunction(func(l *lua.State) int {
-			a := checkVar(l, 2)
-			b := checkVar(l, 3)
-			m := ctx.MidPointOf(a, b)
+			a := checkCtxVar(l, c, 2)
+			b := checkCtxVar(l, c, 3)
+			m := c.newPoint("")
+			c.add(l, key, func() {
+				c.equatePairs(mp.P(0, 0), pairTerm{1, m}, pairTerm{-0.5, a}, pairTerm{-0.5, b})
+			})
 			pushVar(l, m)
 			return 1
 		})
@@ -214,11 +717,13 @@ func contextIndex(l *lua.State) int {
 	case "between":
 		// ctx:between(p, a, b, t) - constrain p = t[a,b]
 		l.PushGoFunction(func(l *lua.State) int {
-			p := checkVar(l, 2)
-			a := checkVar(l, 3)
-			b := checkVar(l, 4)
+			p := checkCtxVar(l, c, 2)
+			a := checkCtxVar(l, c, 3)
+			b := checkCtxVar(l, c, 4)
 			t := lua.CheckNumber(l, 5)
-			ctx.Between(p, a, b, t)
+			c.add(l, key, func() {
+				c.equatePairs(mp.P(0, 0), pairTerm{1, p}, pairTerm{t - 1, a}, pairTerm{-t, b})
+			})
 			l.PushValue(1)
 			return 1
 		})
@@ -227,10 +732,13 @@ func contextIndex(l *lua.State) int {
 	case "betweenat":
 		// ctx:betweenat(a, b, t) - returns new var at t[a,b]
 		l.PushGoFunction(func(l *lua.State) int {
-			a := checkVar(l, 2)
-			b := checkVar(l, 3)
+			a := checkCtxVar(l, c, 2)
+			b := checkCtxVar(l, c, 3)
 			t := lua.CheckNumber(l, 4)
-			p := ctx.BetweenAt(a, b, t)
+			p := c.newPoint("")
+			c.add(l, key, func() {
+				c.equatePairs(mp.P(0, 0), pairTerm{1, p}, pairTerm{t - 1, a}, pairTerm{-t, b})
+			})
 			pushVar(l, p)
 			return 1
 		})
@@ -238,11 +746,14 @@ func contextIndex(l *lua.State) int {
 
 	case "collinear":
 		// ctx:collinear(p, a, b) - constrain p on line through a and b
+		// (b - a must be known)
 		l.PushGoFunction(func(l *lua.State) int {
-			p := checkVar(l, 2)
-			a := checkVar(l, 3)
-			b := checkVar(l, 4)
-			ctx.Collinear(p, a, b)
+			p := checkCtxVar(l, c, 2)
+			a := checkCtxVar(l, c, 3)
+			b := checkCtxVar(l, c, 4)
+			c.add(l, key, func() {
+				c.collinear(p, a, b)
+			})
 			l.PushValue(1)
 			return 1
 		})
@@ -251,13 +762,14 @@ func contextIndex(l *lua.State) int {
 	case "intersection":
 		// ctx:intersection(p, a1, a2, b1, b2) - constrain p = intersection of lines
 		l.PushGoFunction(func(l *lua.State) int {
-			p := checkVar(l, 2)
-			a1 := checkVar(l, 3)
-			a2 := checkVar(l, 4)
-			b1 := checkVar(l, 5)
-			b2 := checkVar(l, 6)
-			err := ctx.Intersection(p, a1, a2, b1, b2)
-			if err != nil {
+			p := checkCtxVar(l, c, 2)
+			a1 := checkCtxVar(l, c, 3)
+			a2 := checkCtxVar(l, c, 4)
+			b1 := checkCtxVar(l, c, 5)
+			b2 := checkCtxVar(l, c, 6)
+			if err := c.constrain(l, key, solverOp{run: func(*solverOp) error {
+				return c.intersection(p, a1, a2, b1, b2)
+			}}); err != nil {
 				lua.Errorf(l, "intersection error: %s", err.Error())
 				return 0
 			}
@@ -269,12 +781,14 @@ func contextIndex(l *lua.State) int {
 	case "intersectionof":
 		// ctx:intersectionof(a1, a2, b1, b2) - returns new var at intersection
 		l.PushGoFunction(func(l *lua.State) int {
-			a1 := checkVar(l, 2)
-			a2 := checkVar(l, 3)
-			b1 := checkVar(l, 4)
-			b2 := checkVar(l, 5)
-			p, err := ctx.IntersectionOf(a1, a2, b1, b2)
-			if err != nil {
+			a1 := checkCtxVar(l, c, 2)
+			a2 := checkCtxVar(l, c, 3)
+			b1 := checkCtxVar(l, c, 4)
+			b2 := checkCtxVar(l, c, 5)
+			p := c.newPoint("")
+			if err := c.constrain(l, key, solverOp{run: func(*solverOp) error {
+				return c.intersection(p, a1, a2, b1, b2)
+			}}); err != nil {
 				lua.Errorf(l, "intersection error: %s", err.Error())
 				return 0
 			}
@@ -286,10 +800,12 @@ func contextIndex(l *lua.State) int {
 	case "sum":
 		// ctx:sum(result, a, b) - constrain result = a + b
 		l.PushGoFunction(func(l *lua.State) int {
-			result := checkVar(l, 2)
-			a := checkVar(l, 3)
-			b := checkVar(l, 4)
-			ctx.Sum(result, a, b)
+			result := checkCtxVar(l, c, 2)
+			a := checkCtxVar(l, c, 3)
+			b := checkCtxVar(l, c, 4)
+			c.add(l, key, func() {
+				c.equatePairs(mp.P(0, 0), pairTerm{1, result}, pairTerm{-1, a}, pairTerm{-1, b})
+			})
 			l.PushValue(1)
 			return 1
 		})
@@ -298,10 +814,12 @@ func contextIndex(l *lua.State) int {
 	case "diff":
 		// ctx:diff(result, a, b) - constrain result = a - b
 		l.PushGoFunction(func(l *lua.State) int {
-			result := checkVar(l, 2)
-			a := checkVar(l, 3)
-			b := checkVar(l, 4)
-			ctx.Diff(result, a, b)
+			result := checkCtxVar(l, c, 2)
+			a := checkCtxVar(l, c, 3)
+			b := checkCtxVar(l, c, 4)
+			c.add(l, key, func() {
+				c.equatePairs(mp.P(0, 0), pairTerm{1, result}, pairTerm{-1, a}, pairTerm{1, b})
+			})
 			l.PushValue(1)
 			return 1
 		})
@@ -310,20 +828,22 @@ func contextIndex(l *lua.State) int {
 	case "scaled":
 		// ctx:scaled(result, v, t) - constrain result = t * v
 		l.PushGoFunction(func(l *lua.State) int {
-			result := checkVar(l, 2)
-			v := checkVar(l, 3)
+			result := checkCtxVar(l, c, 2)
+			v := checkCtxVar(l, c, 3)
 			t := lua.CheckNumber(l, 4)
-			ctx.Scaled(result, v, t)
+			c.add(l, key, func() {
+				c.equatePairs(mp.P(0, 0), pairTerm{1, result}, pairTerm{-t, v})
+			})
 			l.PushValue(1)
 			return 1
 		})
 		return 1
 
 	case "solve":
-		// ctx:solve() - solve the equation system
+		// ctx:solve() - check that all variables are determined; values
+		// are known as soon as the equations determine them
 		l.PushGoFunction(func(l *lua.State) int {
-			err := ctx.Solve()
-			if err != nil {
+			if err := c.solve(); err != nil {
 				lua.Errorf(l, "solve error: %s", err.Error())
 				return 0
 			}
@@ -332,12 +852,87 @@ func contextIndex(l *lua.State) int {
 		})
 		return 1
 
+	case "drag":
+		// ctx:drag(v, x, y) - move a point placed by ctx:known() or
+		// v:setxy() and solve again; everything that depends on it follows
+		l.PushGoFunction(func(l *lua.State) int {
+			v := checkCtxVar(l, c, 2)
+			x := lua.CheckNumber(l, 3)
+			y := lua.CheckNumber(l, 4)
+			if err := c.drag(v, x, y); err != nil {
+				lua.Errorf(l, "drag error: %s", err.Error())
+				return 0
+			}
+			l.PushValue(1)
+			return 1
+		})
+		return 1
+
+	case "snapshot":
+		// ctx:snapshot() - save the constraints given so far
+		l.PushGoFunction(func(l *lua.State) int {
+			l.PushUserData(c.snapshot())
+			lua.SetMetaTableNamed(l, "hobby.snapshot")
+			return 1
+		})
+		return 1
+
+	case "restore":
+		// ctx:restore(snapshot) - drop the constraints given after the
+		// snapshot and solve again
+		l.PushGoFunction(func(l *lua.State) int {
+			s, ok := l.ToUserData(2).(*contextSnapshot)
+			if !ok || s.ctx != c {
+				lua.Errorf(l, "restore: expected a snapshot of this context")
+				return 0
+			}
+			if err := c.restore(s); err != nil {
+				lua.Errorf(l, "restore error: %s", err.Error())
+				return 0
+			}
+			l.PushValue(1)
+			return 1
+		})
+		return 1
+
+	case "check":
+		// ctx:check() - returns ok and a table with the inconsistent and
+		// redundant equations and the names of the unknown variables
+		l.PushGoFunction(func(l *lua.State) int {
+			return c.pushCheck(l)
+		})
+		return 1
+
+	case "report":
+		// ctx:report() - returns a text listing every variable with its
+		// value or its dependency on the unknowns, and the equations that
+		// were redundant or inconsistent
+		l.PushGoFunction(func(l *lua.State) int {
+			l.PushString(c.report())
+			return 1
+		})
+		return 1
+
+	case "isknown":
+		// ctx:isknown(v) - true if a variable or expression is known
+		l.PushGoFunction(func(l *lua.State) int {
+			e := checkExpr(l, 2)
+			if e.ctx != nil && e.ctx != c {
+				lua.Errorf(l, "isknown: the variables belong to another context")
+				return 0
+			}
+			_, _, ok := e.known()
+			l.PushBoolean(ok)
+			return 1
+		})
+		return 1
+
 	case "path":
 		// ctx:path() - create a PathBuilder linked to this context
 		l.PushGoFunction(func(l *lua.State) int {
 			pb := &ContextPathBuilder{
-				builder: ctx.NewPath(),
-				ctx:     ctx,
+				builder: c.ctx.NewPath(),
+				ctx:     c.ctx,
 			}
 			l.PushUserData(pb)
 			lua.SetMetaTableNamed(l, "hobby.ctxpathbuilder")
@@ -346,11 +941,15 @@ func contextIndex(l *lua.State) int {
 		return 1
 	}
 
-	return 0
+	if n := nonlinearIndex(l, c, key); n > 0 {
+		return n
+	}
+	return inequalityIndex(l, c, key)
 }
 
 func varIndex(l *lua.State) int {
-	v := checkVar(l, 1)
+	p := checkVar(l, 1)
+	v := p.v
 	key := lua.CheckString(l, 2)
 
 	switch key {
@@ -374,6 +973,22 @@ func varIndex(l *lua.State) int {
 		})
 		return 1
 
+	case "xpart", "ypart":
+		// v.xpart, v.ypart - the coordinates as numeric expressions, for
+		// equations like ctx:eq(z2.xpart - z1.xpart, 2*(z3.xpart - z2.xpart))
+		e := checkExpr(l, 1)
+		part := e.x
+		if key == "ypart" {
+			part = e.y
+		}
+		pushExpr(l, &solverExpr{ctx: p.ctx, x: part})
+		return 1
+
+	case "name":
+		// v.name - the name used in reports
+		l.PushString(p.name)
+		return 1
+
 	case "point":
 		// v:point() - returns as hobby point
 		l.PushGoFunction(func(l *lua.State) int {
@@ -386,7 +1001,7 @@ func varIndex(l *lua.State) int {
 		// v:setx(x) - set x coordinate
 		l.PushGoFunction(func(l *lua.State) int {
 			x := lua.CheckNumber(l, 2)
-			v.SetX(x)
+			p.ctx.place(l, "setx", p, &x, nil)
 			l.PushValue(1)
 			return 1
 		})
@@ -396,7 +1011,7 @@ func varIndex(l *lua.State) int {
 		// v:sety(y) - set y coordinate
 		l.PushGoFunction(func(l *lua.State) int {
 			y := lua.CheckNumber(l, 2)
-			v.SetY(y)
+			p.ctx.place(l, "sety", p, nil, &y)
 			l.PushValue(1)
 			return 1
 		})
@@ -407,7 +1022,39 @@ func varIndex(l *lua.State) int {
 		l.PushGoFunction(func(l *lua.State) int {
 			x := lua.CheckNumber(l, 2)
 			y := lua.CheckNumber(l, 3)
-			v.SetXY(x, y)
+			p.ctx.place(l, "setxy", p, &x, &y)
+			l.PushValue(1)
+			return 1
+		})
+		return 1
+	}
+
+	return 0
+}
+
+func numericIndex(l *lua.State) int {
+	n := l.ToUserData(1).(*numericVar)
+	key := lua.CheckString(l, 2)
+
+	switch key {
+	case "value":
+		// n.value - the value, 0 while unknown
+		v, _ := n.ctx.solver.value(n.index)
+		l.PushNumber(v)
+		return 1
+
+	case "name":
+		// n.name - the name used in reports
+		l.PushString(n.name)
+		return 1
+
+	case "set":
+		// n:set(v) - set the value
+		l.PushGoFunction(func(l *lua.State) int {
+			v, anon := lua.CheckNumber(l, 2), -1
+			n.ctx.add(l, "set", func() {
+				n.ctx.set(n.index, v, "", &anon)
+			})
 			l.PushValue(1)
 			return 1
 		})
@@ -421,6 +1068,7 @@ func varIndex(l *lua.State) int {
 type ContextPathBuilder struct {
 	builder *draw.PathBuilder
 	ctx     *draw.Context
+	style   styleSpec // applied to the path when it is built
 }
 
 // registerCtxPathBuilderMeta registers the metatable for context-linked path builders
@@ -441,7 +1089,7 @@ func ctxPathBuilderIndex(l *lua.State) int {
 		// pb:movetovar(var) - move to a context variable
 		l.PushGoFunction(func(l *lua.State) int {
 			v := checkVar(l, 2)
-			pb.MoveToVar(v)
+			pb.MoveToVar(v.v)
 			l.PushValue(1)
 			return 1
 		})
@@ -451,7 +1099,7 @@ func ctxPathBuilderIndex(l *lua.State) int {
 		// pb:linetovar(var) - line to a context variable
 		l.PushGoFunction(func(l *lua.State) int {
 			v := checkVar(l, 2)
-			pb.LineToVar(v)
+			pb.LineToVar(v.v)
 			l.PushValue(1)
 			return 1
 		})
@@ -461,7 +1109,7 @@ func ctxPathBuilderIndex(l *lua.State) int {
 		// pb:curvetovar(var) - curve to a context variable
 		l.PushGoFunction(func(l *lua.State) int {
 			v := checkVar(l, 2)
-			pb.CurveToVar(v)
+			pb.CurveToVar(v.v)
 			l.PushValue(1)
 			return 1
 		})
@@ -594,129 +1242,6 @@ func ctxPathBuilderIndex(l *lua.State) int {
 		})
 		return 1
 
-	case "stroke":
-		l.PushGoFunction(func(l *lua.State) int {
-			c := checkColor(l, 2)
-			pb.WithStrokeColor(c)
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "strokewidth":
-		l.PushGoFunction(func(l *lua.State) int {
-			w := lua.CheckNumber(l, 2)
-			pb.WithStrokeWidth(w)
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "fill":
-		l.PushGoFunction(func(l *lua.State) int {
-			c := checkColor(l, 2)
-			pb.WithFill(c)
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "pen":
-		l.PushGoFunction(func(l *lua.State) int {
-			pen := checkPen(l, 2)
-			pb.WithPen(pen)
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "dash":
-		l.PushGoFunction(func(l *lua.State) int {
-			dash := checkDash(l, 2)
-			pb.WithDashPattern(dash)
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "evenly":
-		l.PushGoFunction(func(l *lua.State) int {
-			pb.DashedEvenly()
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "withdots":
-		l.PushGoFunction(func(l *lua.State) int {
-			pb.DashedWithDots()
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "arrow":
-		l.PushGoFunction(func(l *lua.State) int {
-			pb.WithArrow()
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "dblarrow":
-		l.PushGoFunction(func(l *lua.State) int {
-			pb.WithDoubleArrow()
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "arrowstyle":
-		l.PushGoFunction(func(l *lua.State) int {
-			length := lua.CheckNumber(l, 2)
-			angle := lua.CheckNumber(l, 3)
-			pb.WithArrowStyle(length, angle)
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "linejoin":
-		l.PushGoFunction(func(l *lua.State) int {
-			join := lua.CheckString(l, 2)
-			switch join {
-			case "miter":
-				pb.WithLineJoin(mp.LineJoinMiter)
-			case "round":
-				pb.WithLineJoin(mp.LineJoinRound)
-			case "bevel":
-				pb.WithLineJoin(mp.LineJoinBevel)
-			default:
-				lua.Errorf(l, "unknown linejoin: %s", join)
-			}
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "linecap":
-		l.PushGoFunction(func(l *lua.State) int {
-			cap := lua.CheckString(l, 2)
-			switch cap {
-			case "butt":
-				pb.WithLineCap(mp.LineCapButt)
-			case "round":
-				pb.WithLineCap(mp.LineCapRounded)
-			case "square":
-				pb.WithLineCap(mp.LineCapSquared)
-			default:
-				lua.Errorf(l, "unknown linecap: %s", cap)
-			}
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
 	case "close", "cycle":
 		l.PushGoFunction(func(l *lua.State) int {
 			pb.Close()
@@ -732,11 +1257,21 @@ func ctxPathBuilderIndex(l *lua.State) int {
 				lua.Errorf(l, "path build error: %s", err.Error())
 				return 0
 			}
+			buildStyled(path, cpb.style)
 			pushPath(l, path)
 			return 1
 		})
 		return 1
 	}
 
+	if isStyleMethod(key) {
+		l.PushGoFunction(func(l *lua.State) int {
+			cpb.style = cpb.style.with(checkStyleMethod(l, key))
+			l.PushValue(1)
+			return 1
+		})
+		return 1
+	}
+
 	return 0
 }
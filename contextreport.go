@@ -0,0 +1,195 @@
+package hobby
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	lua "github.com/speedata/go-lua"
+)
+
+// unknownName returns the name of unknown i.
+func (c *solverContext) unknownName(i int) string {
+	return c.names[i]
+}
+
+// formatNumber formats v for reports.
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'g', 6, 64)
+}
+
+// formatExpr returns e with the names of the unknowns, e.g.
+// "0.5*z1.x + 10".
+func (c *solverContext) formatExpr(e *linExpr) string {
+	var b strings.Builder
+	for _, i := range e.unknowns() {
+		coef := e.terms[i]
+		switch {
+		case b.Len() == 0 && coef < 0:
+			b.WriteString("-")
+		case b.Len() > 0 && coef < 0:
+			b.WriteString(" - ")
+		case b.Len() > 0:
+			b.WriteString(" + ")
+		}
+		if a := math.Abs(coef); a != 1 {
+			b.WriteString(formatNumber(a) + "*")
+		}
+		b.WriteString(c.unknownName(i))
+	}
+	switch {
+	case b.Len() == 0:
+		return formatNumber(e.constant)
+	case e.constant > 0:
+		b.WriteString(" + " + formatNumber(e.constant))
+	case e.constant < 0:
+		b.WriteString(" - " + formatNumber(-e.constant))
+	}
+	return b.String()
+}
+
+// describe returns a description of an equation for messages, e.g.
+// "equation 3 (midpoint at fig.lua:12, x)".
+func (r equationRecord) describe() string {
+	desc := fmt.Sprintf("equation %d (%s", r.n, r.kind)
+	if r.where != "" {
+		desc += " at " + r.where
+	}
+	if r.part != "" {
+		desc += ", " + r.part
+	}
+	return desc + ")"
+}
+
+// unknowns returns the names of the variable coordinates and numerics
+// that are not known.
+func (c *solverContext) unknowns() []string {
+	var names []string
+	for _, p := range c.points {
+		for i := p.x; i <= p.x+1; i++ {
+			if _, ok := c.solver.value(i); !ok {
+				names = append(names, c.unknownName(i))
+			}
+		}
+	}
+	for _, n := range c.numerics {
+		if _, ok := c.solver.value(n.index); !ok {
+			names = append(names, n.name)
+		}
+	}
+	return names
+}
+
+// recordsWith returns the equations with the given status.
+func (c *solverContext) recordsWith(status equationStatus) []equationRecord {
+	var records []equationRecord
+	for _, r := range c.records {
+		if r.status == status {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+// solve solves the inequalities and nonlinear constraints, if there are
+// any, and checks that the system is consistent and all variables are
+// known.
+func (c *solverContext) solve() error {
+	if len(c.nonlinear) > 0 || len(c.inequalities) > 0 || len(c.objective) > 0 {
+		// from the equations alone, without the values fixed by the last
+		// solve
+		if err := c.replay(); err != nil {
+			return err
+		}
+	}
+	if bad := c.recordsWith(equationInconsistent); len(bad) > 0 {
+		return fmt.Errorf("inconsistent %s, off by %s", bad[0].describe(), formatNumber(bad[0].off))
+	}
+	if unknown := c.unknowns(); len(unknown) > 0 {
+		const shown = 8
+		list := strings.Join(unknown[:min(len(unknown), shown)], ", ")
+		if len(unknown) > shown {
+			list += fmt.Sprintf(" and %d more", len(unknown)-shown)
+		}
+		return fmt.Errorf("underdetermined system: %s unknown", list)
+	}
+	return nil
+}
+
+// report lists the variables with their values or dependencies, followed
+// by the equations that were redundant or inconsistent.
+func (c *solverContext) report() string {
+	var b strings.Builder
+	line := func(name string, i int) {
+		d := c.solver.deps[i]
+		switch {
+		case d == nil:
+			fmt.Fprintf(&b, "%s is independent\n", name)
+		default:
+			fmt.Fprintf(&b, "%s = %s\n", name, c.formatExpr(d))
+		}
+	}
+	for _, p := range c.points {
+		line(p.name+".x", p.x)
+		line(p.name+".y", p.x+1)
+	}
+	for _, n := range c.numerics {
+		line(n.name, n.index)
+	}
+	for _, r := range c.records {
+		switch r.status {
+		case equationRedundant:
+			fmt.Fprintf(&b, "%s is redundant\n", r.describe())
+		case equationInconsistent:
+			fmt.Fprintf(&b, "%s is inconsistent, off by %s\n", r.describe(), formatNumber(r.off))
+		}
+	}
+	return b.String()
+}
+
+// pushRecords pushes a list of equations as a Lua table of
+// {n, kind, where, part, off} tables.
+func pushRecords(l *lua.State, records []equationRecord) {
+	l.CreateTable(len(records), 0)
+	for i, r := range records {
+		l.CreateTable(0, 5)
+		l.PushInteger(r.n)
+		l.SetField(-2, "n")
+		l.PushString(r.kind)
+		l.SetField(-2, "kind")
+		l.PushString(r.where)
+		l.SetField(-2, "where")
+		if r.part != "" {
+			l.PushString(r.part)
+			l.SetField(-2, "part")
+		}
+		if r.status == equationInconsistent {
+			l.PushNumber(r.off)
+			l.SetField(-2, "off")
+		}
+		l.PushString(r.describe())
+		l.SetField(-2, "description")
+		l.RawSetInt(-2, i+1)
+	}
+}
+
+// pushCheck pushes the result of ctx:check(): ok and a table with the
+// inconsistent and redundant equations and the unknown variables.
+func (c *solverContext) pushCheck(l *lua.State) int {
+	inconsistent := c.recordsWith(equationInconsistent)
+	unknown := c.unknowns()
+	l.PushBoolean(len(inconsistent) == 0 && len(unknown) == 0)
+	l.CreateTable(0, 3)
+	pushRecords(l, inconsistent)
+	l.SetField(-2, "inconsistent")
+	pushRecords(l, c.recordsWith(equationRedundant))
+	l.SetField(-2, "redundant")
+	l.CreateTable(len(unknown), 0)
+	for i, name := range unknown {
+		l.PushString(name)
+		l.RawSetInt(-2, i+1)
+	}
+	l.SetField(-2, "unknown")
+	return 2
+}
@@ -0,0 +1,64 @@
+-- Bounding boxes: truebbox and setbounds
+--
+-- path:truebbox() and pic:truebbox() measure what is actually drawn:
+-- pen shapes, miter joins, square caps and arrow heads. They are used
+-- here to line up figures with exactly 4pt between their ink.
+-- pic:setbounds(path) overrides the bounds used for layout and for the
+-- SVG viewBox.
+
+local h = require("hobby")
+
+local triangle = h.path()
+    :moveto(h.point(0, 0))
+    :lineto(h.point(30, 0))
+    :lineto(h.point(15, 30))
+    :cycle()
+    :build()
+    :strokewidth(4)
+    :linejoin("miter")
+    :stroke("darkred")
+
+local bar = h.path()
+    :moveto(h.point(0, 0))
+    :lineto(h.point(0, 30))
+    :build()
+    :strokewidth(6)
+    :linecap("square")
+    :stroke("darkgreen")
+
+local swash = h.path()
+    :moveto(h.point(0, 0))
+    :curveto(h.point(15, 30))
+    :curveto(h.point(30, 0))
+    :build()
+    :pen(h.pencircle(1):xscaled(6):rotated(30))
+    :stroke("navy")
+
+local arrow = h.path()
+    :moveto(h.point(0, 15))
+    :lineto(h.point(30, 15))
+    :arrow()
+    :build()
+    :strokewidth(1.5)
+
+local fig = h.picture()
+local x = 0
+for _, p in ipairs({ triangle, bar, swash, arrow }) do
+    -- shift each figure so that its ink starts at x
+    local box = p:truebbox()
+    local placed = p:shifted(x - box.llcorner.x, 0)
+    fig:add(placed)
+    fig:add(placed:truebbox():evenly():stroke("gray"):strokewidth(0.3))
+    x = placed:truebbox().urcorner.x + 4
+end
+
+-- Use the ink plus a 4pt margin as the viewBox
+local ll = fig:truebbox().llcorner
+local ur = fig:truebbox().urcorner
+fig:setbounds(h.rect(ll.x - 4, ll.y - 4, ur.x - ll.x + 8, ur.y - ll.y + 8))
+
+h.svg()
+    :addpicture(fig)
+    :write("bounds.svg")
+
+print("Created bounds.svg")
@@ -0,0 +1,41 @@
+-- Calligraphy with polygonal pens
+--
+-- SVG strokes are always drawn with a round pen. Paths drawn with a
+-- polygonal pen (pensquare, penrazor or a pen from makepen) are written
+-- as the envelope the pen sweeps, filled with the stroke color; elliptical
+-- pens (pencircle) keep using SVG strokes.
+
+local h = require("hobby")
+
+local svg = h.svg():padding(10)
+
+-- A letter "S" written with a broad nib held at 30 degrees
+local s = h.path()
+    :moveto(h.point(30, 52))
+    :curveto(h.point(15, 58))
+    :curveto(h.point(6, 46))
+    :curveto(h.point(20, 30))
+    :curveto(h.point(32, 12))
+    :curveto(h.point(18, 2))
+    :curveto(h.point(4, 8))
+    :build()
+
+local nib = h.penrazor(7, 30)
+svg:add(s:pen(nib):stroke("#1f3b73"))
+
+-- The same letter, moved and drawn with a turned nib and a square pen
+svg:add(s:shifted(40, 0):pen(nib:rotated(90)):stroke("#1f3b73"))
+svg:add(s:shifted(80, 0):pen(h.pensquare(3)):stroke("#7a2e1a"))
+
+-- For comparison: the elliptical pen is an SVG stroke
+svg:add(s:shifted(120, 0):pen(h.pencircle(3)):stroke("#555555"))
+
+-- A closed shape filled and outlined with a razor pen
+svg:add(h.fullcircle():scaled(30):shifted(185, 28)
+    :fill("#f3e3b5")
+    :pen(h.penrazor(4, -45))
+    :stroke("#7a2e1a"))
+
+svg:write("calligraphy.svg")
+
+print("Created calligraphy.svg")
@@ -0,0 +1,49 @@
+-- Nested clipping: a magnifier inset inside a clipped frame
+--
+-- pic:clip(path) clips everything drawn so far. Content added later is
+-- not affected, and clipping twice intersects the two regions.
+
+local h = require("hobby")
+
+-- A grid of diagonal lines as background texture
+local function texture(pic, spacing, color)
+    for i = -10, 10 do
+        pic:add(h.path()
+            :moveto(h.point(i * spacing, 0))
+            :lineto(h.point(i * spacing + 100, 100))
+            :build()
+            :stroke(color)
+            :strokewidth(0.5))
+    end
+end
+
+-- The magnifier: a fine texture clipped to a small disc
+local lens = h.fullcircle():scaled(30):shifted(82, 58)
+local inset = h.picture()
+texture(inset, 2, "darkred")
+inset:clip(lens)
+-- The rim is added after the clip and is drawn in full
+inset:add(lens:stroke("darkred"):strokewidth(1.5))
+
+-- The frame: a coarse texture clipped to a rounded rectangle ...
+local frame = h.rect(10, 10, 80, 60, { radius = 8 })
+local pic = h.picture()
+texture(pic, 8, "gray")
+pic:clip(frame)
+-- ... and once more to a disc, which leaves the intersection
+pic:clip(h.fullcircle():scaled(90):shifted(50, 40))
+
+-- The inset is nested inside; the lens sticks out of the frame but is
+-- clipped by the frame as well
+pic:addpicture(inset)
+pic:clip(frame)
+
+-- Outlines added after clipping
+pic:add(frame:stroke("black"))
+
+h.svg()
+    :padding(5)
+    :addpicture(pic)
+    :write("clipnested.svg")
+
+print("Created clipnested.svg")
@@ -0,0 +1,44 @@
+-- Color models: gray, CMYK and spot colors
+--
+-- Colors keep the model they were created in (c.model, c.components).
+-- The SVG output shows their RGB equivalent. c:torgb(), c:tocmyk() and
+-- c:togray() convert between the models.
+
+local h = require("hobby")
+
+local svg = h.svg():padding(5)
+
+local function swatch(x, y, color)
+    svg:add(h.rect(x, y, 18, 18):fill(color):stroke("none"))
+end
+
+-- Process colors and black
+local process = { h.cmyk(1, 0, 0, 0), h.cmyk(0, 1, 0, 0), h.cmyk(0, 0, 1, 0), h.cmyk(0, 0, 0, 1) }
+for i, c in ipairs(process) do
+    swatch((i - 1) * 20, 40, c)
+end
+
+-- Tints of a spot color with a CMYK fallback
+local blue = h.cmyk(1, 0.66, 0, 0.02)
+for i = 1, 5 do
+    swatch((i - 1) * 20, 20, h.spot("PANTONE 286 C", blue, i / 5))
+end
+
+-- A gray ramp in the gray model
+for i = 0, 4 do
+    swatch(i * 20, 0, h.gray(i / 4):togray())
+end
+
+-- Conversions: an RGB color converted to CMYK and to gray
+local orange = h.color("darkorange")
+swatch(110, 40, orange)
+swatch(110, 20, orange:tocmyk())
+swatch(110, 0, orange:togray())
+
+for _, c in ipairs{ orange, orange:tocmyk(), orange:togray(), h.spot("PANTONE 286 C", blue, 0.4) } do
+    print(c.model, c)
+end
+
+svg:write("colormodels.svg")
+
+print("Created colormodels.svg")
@@ -0,0 +1,51 @@
+-- Color arithmetic and color spaces
+--
+-- Colors can be added, subtracted and scaled like in MetaPost, and
+-- h.mix(a, b, t) is MetaPost's t[a,b]. h.hsl, h.hsv and h.lab create
+-- colors from other color spaces; c:hsl(), c:hsv() and c:lab() read
+-- them back.
+
+local h = require("hobby")
+
+local svg = h.svg():padding(5)
+local n = 12
+
+local function swatch(i, y, color)
+    svg:add(h.rect(i * 10, y, 10, 8):fill(color):stroke("none"))
+end
+
+local red, blue = h.color("firebrick"), h.color("steelblue")
+
+-- MetaPost style: t[red,blue] in RGB
+for i = 0, n do
+    swatch(i, 40, h.mix(red, blue, i / n))
+end
+
+-- The same ramp interpolated in L*a*b*, which keeps the lightness even
+local l1, a1, b1 = red:lab()
+local l2, a2, b2 = blue:lab()
+for i = 0, n do
+    local t = i / n
+    swatch(i, 30, h.lab(l1 + t * (l2 - l1), a1 + t * (a2 - a1), b1 + t * (b2 - b1)))
+end
+
+-- Tints and shades: scaling toward black, mixing toward white
+for i = 0, n do
+    swatch(i, 20, (1 - i / (2 * n)) * red + (i / (2 * n)) * h.color("white"))
+end
+
+-- A hue circle in HSL
+for i = 0, n do
+    swatch(i, 10, h.hsl(360 * i / (n + 1), 0.7, 0.5))
+end
+
+-- Arithmetic on CMYK colors stays in CMYK
+local cyan, magenta = h.cmyk(1, 0, 0, 0), h.cmyk(0, 1, 0, 0)
+for i = 0, n do
+    swatch(i, 0, h.mix(cyan, magenta, i / n) * 0.8)
+end
+print(h.mix(cyan, magenta, 0.5) * 0.8)
+
+svg:write("colorramps.svg")
+
+print("Created colorramps.svg")
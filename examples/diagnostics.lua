@@ -0,0 +1,55 @@
+-- Solver diagnostics
+--
+-- Variables can be named, ctx:isknown(v) tells whether a variable or an
+-- expression is determined yet, ctx:check() returns the inconsistent and
+-- redundant equations and the unknown variables, and ctx:report() lists
+-- every variable with its value or its dependency on the unknowns.
+
+local h = require("hobby")
+
+local ctx = h.context()
+
+-- A triangle z1 z2 z3 with the midpoints of its sides
+local z = ctx:points(3, "z")
+local m = ctx:points(3, "m")
+ctx:eq(z[1], h.point(0, 0))
+ctx:eq(z[2], z[1] + h.point(160, 0))
+ctx:eq(z[3].ypart, 90)
+for i = 1, 3 do
+    ctx:midpoint(m[i], z[i], z[i % 3 + 1])
+end
+
+-- The equal heights of z1 and z2 follow from the equations above
+ctx:eqvary(z[2], z[1])
+
+-- z3.x is still open, so m2 and m3 are only half known
+print("m2 known:", ctx:isknown(m[2]), "m2.y known:", ctx:isknown(m[2].ypart))
+print("m2 =", tostring(m[2]))
+
+local ok, result = ctx:check()
+print("ok:", ok, "unknown: " .. table.concat(result.unknown, ", "))
+for _, eq in ipairs(result.redundant) do
+    print("redundant: " .. eq.description)
+end
+
+-- Fix the apex and everything is known
+ctx:eq(z[3].xpart, 0.3 * z[2].xpart)
+ctx:solve()
+print(ctx:report())
+
+local pic = h.picture()
+pic:add(h.path():moveto(z[1]:point()):lineto(z[2]:point()):lineto(z[3]:point())
+    :close():build():fill("#dbe7f3"):stroke("#1f3b73"):strokewidth(0.8))
+pic:add(h.path():moveto(m[1]:point()):lineto(m[2]:point()):lineto(m[3]:point())
+    :close():build():stroke("#c0392b"):strokewidth(0.6):evenly())
+for i = 1, 3 do
+    pic:dotlabel(z[i].name, z[i]:point(), i == 3 and "top" or "bot", h.color("black"))
+    pic:dotlabel(m[i].name, m[i]:point(), i == 1 and "bot" or "top", h.color("#c0392b"))
+end
+
+h.svg()
+    :padding(10)
+    :addpicture(pic)
+    :write("diagnostics.svg")
+
+print("Created diagnostics.svg")
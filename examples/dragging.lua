@@ -0,0 +1,56 @@
+-- Whatever, dragging and snapshots
+--
+-- ctx:whatever() is a fresh anonymous unknown like MetaPost's whatever:
+-- z = whatever*(b - a) + a puts z somewhere on the line through a and b.
+-- ctx:drag(v, x, y) moves a point placed with ctx:known() or v:setxy()
+-- and solves the system again, so everything built on it follows without
+-- building a new context. ctx:snapshot() and ctx:restore() go back to an
+-- earlier set of constraints.
+
+local h = require("hobby")
+
+local ctx = h.context()
+
+-- A triangle and its centroid as the intersection of two medians
+local a = ctx:known(0, 0, "a")
+local b = ctx:known(120, 0, "b")
+local c = ctx:known(30, 90, "c")
+local mab = ctx:midpointof(a, b)
+local mbc = ctx:midpointof(b, c)
+local g = ctx:point("g")
+ctx:eq(g, ctx:whatever() * (mab - c) + c)
+ctx:eq(g, ctx:whatever() * (mbc - a) + a)
+ctx:solve()
+
+local pic = h.picture()
+local colors = { "#1f3b73", "#2e86c1", "#85c1e9" }
+local function frame(color)
+    pic:add(h.path():moveto(a:point()):lineto(b:point()):lineto(c:point())
+        :close():build():stroke(color):strokewidth(0.8))
+    pic:add(h.path():moveto(c:point()):lineto(mab:point()):build()
+        :stroke(color):strokewidth(0.4):evenly())
+    pic:add(h.path():moveto(a:point()):lineto(mbc:point()):build()
+        :stroke(color):strokewidth(0.4):evenly())
+    pic:add(h.fullcircle():scaled(3):shifted(g.x, g.y):fill(color))
+end
+
+-- Drag the apex to the right; the medians and the centroid follow
+for i, x in ipairs({ 30, 90, 150 }) do
+    ctx:drag(c, x, 90 - 15 * (i - 1))
+    frame(colors[i])
+end
+
+-- Try an extra constraint and go back
+local saved = ctx:snapshot()
+local top = ctx:point("top")
+ctx:eq(top, c + h.point(0, 20))
+print("top known:", ctx:isknown(top))
+ctx:restore(saved)
+print("after restore:", ctx:isknown(top))
+
+h.svg()
+    :padding(10)
+    :addpicture(pic)
+    :write("dragging.svg")
+
+print("Created dragging.svg")
@@ -0,0 +1,50 @@
+-- Equations with variable arithmetic
+--
+-- Point and numeric variables of a context can be added, subtracted,
+-- negated, multiplied with known numbers and divided by them. The result
+-- is a linear expression that ctx:eq() accepts, so equations read like
+-- MetaPost's: z1 + z3 = z2 + z4, z5 = (z1 + z2 + z3)/3.
+
+local h = require("hobby")
+
+local ctx = h.context()
+local z1 = ctx:known(0, 0)
+local z2 = ctx:known(90, 10)
+local z3 = ctx:known(120, 70)
+local z4, z5, z6, z7 = ctx:unknown(), ctx:unknown(), ctx:unknown(), ctx:unknown()
+
+-- z4 completes the parallelogram z1 z2 z3 z4
+ctx:eq(z1 + z3, z2 + z4)
+-- z5 is the centroid of the triangle z1 z2 z3
+ctx:eq(3 * z5, z1 + z2 + z3)
+
+-- z6 lies on the diagonal z1--z3 with an unknown factor t, and on the
+-- vertical through the centroid
+local t = ctx:numeric()
+ctx:eq(z6 - z1, t * (z3 - z1))
+ctx:eq(z6.xpart, z5.xpart)
+
+-- z7: the distance z2 to z7 is twice the distance z7 to z3 on the line
+ctx:eq(z7 - z2, 2 * (z3 - z7))
+
+ctx:solve()
+print(string.format("t = %.3f", t.value))
+
+local pic = h.picture()
+local black = h.color("black")
+pic:add(h.path():moveto(z1:point()):lineto(z2:point()):lineto(z3:point())
+    :lineto(z4:point()):cycle():build():stroke("#1f3b73"))
+pic:add(h.path():moveto(z1:point()):lineto(z3:point()):build()
+    :stroke("gray"):strokewidth(0.4):dash(h.evenly()))
+pic:add(h.path():moveto(z5:point()):lineto(z6:point()):build()
+    :stroke("#c0392b"):strokewidth(0.4))
+for i, z in ipairs({ z1, z2, z3, z4, z5, z6, z7 }) do
+    pic:dotlabel("z" .. i, z:point(), "top", black)
+end
+
+h.svg()
+    :padding(15)
+    :addpicture(pic)
+    :write("equations.svg")
+
+print("Created equations.svg")
@@ -0,0 +1,45 @@
+-- Gradient fills: a shaded cylinder, a sphere and a gradient stroke
+--
+-- h.lineargradient and h.radialgradient return values that can be used
+-- wherever a color is expected. The gradient coordinates are in the same
+-- coordinate system as the path; transforming the path transforms the
+-- gradient as well.
+
+local h = require("hobby")
+
+local svg = h.svg():padding(5)
+
+-- Cylinder: a rectangle shaded from left to right, with elliptic caps
+local w, ht = 40, 60
+local body = h.lineargradient(h.point(0, 0), h.point(w, 0), {
+    { 0, "#3a4a5a" }, { 0.35, "#d8e4ef" }, { 1, "#2a3440" },
+})
+svg:add(h.rect(0, 0, w, ht):fill(body):stroke("none"))
+svg:add(h.ellipse(h.point(w / 2, 0), w / 2, 6):fill(body):stroke("none"))
+svg:add(h.ellipse(h.point(w / 2, ht), w / 2, 6)
+    :fill(h.lineargradient(h.point(0, ht), h.point(w, ht), { "#9fb3c6", "#e9f0f6" }))
+    :stroke("#2a3440"):strokewidth(0.5))
+
+-- Sphere: the highlight is off center, like MetaFun's circular shading
+local c = h.point(90, 30)
+local sphere = h.radialgradient(h.point(80, 42), 0, c, 30, {
+    { 0, "white" }, { 0.25, "#f2a65a" }, { 1, "#7a2e05" },
+})
+svg:add(h.fullcircle():scaled(60):shifted(c.x, c.y):fill(sphere):stroke("none"))
+
+-- A squashed copy: the gradient is transformed with the path
+local egg = h.fullcircle():scaled(60):shifted(c.x, c.y):fill(sphere):stroke("none")
+svg:add(egg:xscaled(0.6):rotated(20):shifted(80, -10))
+
+-- Gradients also work for strokes
+svg:add(h.path()
+    :moveto(h.point(0, -20))
+    :curveto(h.point(80, -10))
+    :curveto(h.point(160, -20))
+    :build()
+    :strokewidth(4)
+    :stroke(h.lineargradient(h.point(0, 0), h.point(160, 0), { "seagreen", "gold", "crimson" })))
+
+svg:write("gradients.svg")
+
+print("Created gradients.svg")
@@ -0,0 +1,59 @@
+-- Hatching and pattern fills
+--
+-- path:hatch{} cuts parallel lines at the outline of a region and returns
+-- them as a picture of real paths, which is what plotters and section
+-- views in technical drawings need. h.pattern(picture) turns a picture
+-- into a fill that repeats it.
+
+local h = require("hobby")
+
+local pic = h.picture()
+
+-- Section of a bushing: two hatched halves with opposite directions
+local left = h.rect(0, 0, 12, 40)
+local right = h.rect(28, 0, 12, 40)
+pic:addpicture(left:hatch{ angle = 45, spacing = 2.5, width = 0.3 })
+pic:addpicture(right:hatch{ angle = -45, spacing = 2.5, width = 0.3 })
+pic:add(left:strokewidth(0.8))
+pic:add(right:strokewidth(0.8))
+pic:add(h.path():moveto(h.point(20, -4)):lineto(h.point(20, 44)):build()
+    :dash(h.dashed(6, 2, 1, 2)):strokewidth(0.3))
+
+-- A curved region with two hatch layers (cross-hatching)
+local blob = h.path()
+    :moveto(h.point(55, 5))
+    :curveto(h.point(80, 0))
+    :curveto(h.point(90, 25))
+    :curveto(h.point(70, 40))
+    :curveto(h.point(52, 28))
+    :cycle()
+    :build()
+pic:addpicture(blob:hatch{ angle = 30, spacing = 3, stroke = "steelblue", width = 0.3 })
+pic:addpicture(blob:hatch{ angle = 120, spacing = 3, stroke = "steelblue", width = 0.3 })
+pic:add(blob:stroke("steelblue"))
+
+-- Pattern fills: dots on a 4 x 4 grid and a brick wall
+local dot = h.picture()
+dot:add(h.fullcircle():scaled(1.5):shifted(2, 2):fill("darkred"):stroke("none"))
+local dots = h.pattern(dot, { width = 4, height = 4 })
+
+local brick = h.picture()
+local mortar = { stroke = "gray", width = 0.4 }
+local function line(x1, y1, x2, y2)
+    brick:add(h.path():moveto(h.point(x1, y1)):lineto(h.point(x2, y2)):build():withstyle(mortar))
+end
+line(0, 2, 12, 2)
+line(0, 6, 12, 6)
+line(3, 2, 3, 6)
+line(9, 6, 9, 8)
+line(9, 0, 9, 2)
+local bricks = h.pattern(brick, { width = 12, height = 8 })
+
+pic:add(h.fullcircle():scaled(36):shifted(18, -30):fill(dots))
+pic:add(h.rect(45, -48, 45, 36):fill(bricks))
+-- Patterns turn with the path they fill
+pic:add(h.rect(0, 0, 30, 20):fill(bricks):rotated(20):shifted(100, -45))
+
+h.svg():padding(5):addpicture(pic):write("hatch.svg")
+
+print("Created hatch.svg")
@@ -0,0 +1,28 @@
+-- Interpolation example: morphing a square into a circle
+
+local h = require("hobby")
+
+-- Start and end shapes with different knot counts (4 vs. 8)
+local square = h.unitsquare()
+    :scaled(40)
+    :stroke("red")
+    :strokewidth(1)
+
+local circle = h.fullcircle()
+    :scaled(40)
+    :shifted(20, 20)
+    :stroke("blue")
+    :strokewidth(3)
+
+-- Knots are inserted into the square automatically, so both paths
+-- have the same length before they are blended
+local svg = h.svg():padding(5)
+for i = 0, 5 do
+    local t = i / 5
+    local frame = h.interpolate(square, circle, t):shifted(i * 55, 0)
+    svg:add(frame)
+    print(string.format("t=%.1f length=%d center=%s", t, frame.length, tostring(frame.center)))
+end
+
+svg:write("interpolate.svg")
+print("Created interpolate.svg")
@@ -0,0 +1,45 @@
+-- Join and concatenation example: building outlines from pieces
+
+local h = require("hobby")
+
+local circle = h.fullcircle():scaled(60)
+
+-- Upper half of the circle
+local arc = circle:subpath(0, 4)
+
+-- join (MetaPost's &): the end of arc and the start of the base coincide
+local base = h.path()
+    :moveto(arc:pointat(arc.length))
+    :lineto(h.point(0, -40))
+    :lineto(arc:pointat(0))
+    :build()
+local drop = arc:join(base):stroke("blue"):strokewidth(1)
+
+-- concat with a straight segment (MetaPost's --)
+local left = h.fullcircle():scaled(20):subpath(2, 6):shifted(80, 0)
+local right = h.fullcircle():scaled(20):subpath(2, 6):rotated(180):shifted(120, 0)
+local capsule = left:concat(right, {mode = "line"})
+    :concat(left:pointat(0), {mode = "line"})
+capsule = capsule:stroke("red"):strokewidth(1)
+
+-- concat with a curve (MetaPost's ..); the Lua .. operator does the same
+-- when the endpoints do not coincide
+local wave = h.path()
+    :moveto(h.point(160, 0))
+    :curveto(h.point(180, 20))
+    :build()
+local tail = h.path()
+    :moveto(h.point(200, 20))
+    :curveto(h.point(220, 0))
+    :build()
+local smooth = wave .. tail
+smooth = smooth:stroke("green"):strokewidth(1)
+
+h.svg()
+    :padding(5)
+    :add(drop)
+    :add(capsule)
+    :add(smooth)
+    :write("join.svg")
+
+print("Created join.svg")
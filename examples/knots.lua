@@ -0,0 +1,38 @@
+-- Knot editing example: post-processing a solved path
+
+local h = require("hobby")
+
+-- A smooth closed curve
+local blob = h.path()
+    :moveto(h.point(0, 0))
+    :curveto(h.point(60, 10))
+    :curveto(h.point(50, 60))
+    :curveto(h.point(10, 50))
+    :cycle()
+    :stroke("gray")
+    :build()
+
+-- Copy it and force a corner at the second knot by pulling
+-- both control points onto the knot itself
+local cornered = blob:shifted(90, 0):stroke("blue"):strokewidth(1)
+local k = cornered:knots()[2]
+k.precontrol = k.point
+k.postcontrol = k.point
+
+-- Insert a knot halfway along the last segment and move it outward
+cornered:insertknot(3.5)
+local knots = cornered:knots()
+local mid = knots[#knots]
+print("inserted knot:", tostring(mid))
+cornered:setknot(#knots, {
+    point = mid.point + h.point(-10, 0),
+    precontrol = mid.precontrol + h.point(-10, 0),
+    postcontrol = mid.postcontrol + h.point(-10, 0),
+})
+
+local svg = h.svg():padding(5):add(blob):add(cornered)
+for _, kn in ipairs(cornered:knots()) do
+    svg:add(h.fullcircle():scaled(3):shifted(kn.point.x, kn.point.y):fill("red"))
+end
+svg:write("knots.svg")
+print("Created knots.svg")
@@ -0,0 +1,42 @@
+-- Groups, layers and z-order: the SVG output gets one <g> per group
+
+local h = require("hobby")
+
+local pic = h.picture()
+
+-- Layers are stacked in the order they are first used
+local background = pic:layer("background")
+local shapes = pic:layer("shapes")
+local annotations = pic:layer("annotations")
+
+background:add(h.rect(-10, -10, 140, 70):fill("whitesmoke"):stroke("none"))
+
+-- Nested groups get an id and an optional class
+local nodes = shapes:group("nodes", "node")
+local a = h.fullcircle():scaled(30):shifted(20, 25):fill("lightblue"):stroke("blue")
+local b = h.fullcircle():scaled(30):shifted(100, 25):fill("lightgreen"):stroke("green")
+nodes:add(a, {id = "node-a"})
+nodes:add(b, {id = "node-b"})
+
+local edges = shapes:group("edges", "edge")
+local edge = h.path()
+    :moveto(h.point(20, 25))
+    :lineto(h.point(100, 25))
+    :build()
+    :stroke("red")
+    :strokewidth(2)
+edges:add(edge, {id = "edge-ab"})
+
+-- The edges group was created last and would hide the node fills;
+-- lower it below the nodes
+shapes:lower(edges)
+
+annotations:label("A", h.point(20, 25), "center")
+annotations:label("B", h.point(100, 25), "center")
+
+h.svg()
+    :padding(5)
+    :addpicture(pic)
+    :write("layers.svg")
+
+print("Created layers.svg")
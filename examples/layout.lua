@@ -0,0 +1,68 @@
+-- Inequalities and objectives
+--
+-- ctx:ge(a, b) and ctx:le(a, b) constrain expressions (pairs
+-- coordinatewise), ctx:minimize(e) and ctx:maximize(e) give an objective.
+-- ctx:solve() solves them as a linear program together with the
+-- equations.
+
+local h = require("hobby")
+
+local ctx = h.context()
+
+-- Boxes of given widths at least 15 apart; the third box must not start
+-- before x = 170. The row is made as short as possible.
+local widths = { 40, 60, 30, 50 }
+local left = {}
+for i, w in ipairs(widths) do
+    left[i] = ctx:numeric("left" .. i)
+    if i == 1 then
+        ctx:ge(left[i], 0)
+    else
+        ctx:ge(left[i] - left[i - 1], widths[i - 1] + 15)
+    end
+end
+ctx:ge(left[3], 170)
+ctx:minimize(left[#widths])
+
+-- A label as close as possible to x = 100 that keeps 5 away from the
+-- boxes 2 and 3: |label - 100| <= d with d minimized
+local label = ctx:numeric("label")
+local d = ctx:numeric("d")
+ctx:ge(label, left[2] + widths[2] + 5)
+ctx:le(label, left[3] - 5)
+ctx:ge(d, label - 100)
+ctx:ge(d, 100 - label)
+ctx:minimize(d)
+
+-- A point inside the frame of the row, as far right and low as possible
+local z = ctx:point("z")
+ctx:ge(z, h.point(0, -40))
+ctx:le(z, ctx:known(0, 0) + h.point(250, -10))
+ctx:maximize(z.xpart - z.ypart)
+
+ctx:solve()
+
+local pic = h.picture()
+for i, w in ipairs(widths) do
+    local x = left[i].value
+    pic:add(h.rect(x, 0, w, 20):fill("#dbe7f3"):stroke("#1f3b73"):strokewidth(0.5))
+    if i > 1 then
+        local from = left[i - 1].value + widths[i - 1]
+        pic:add(h.path():moveto(h.point(from, 10)):lineto(h.point(x, 10)):arrow():build()
+            :stroke("#1f3b73"):strokewidth(0.5))
+    end
+end
+pic:add(h.path():moveto(h.point(170, -5)):lineto(h.point(170, 30)):build()
+    :stroke("#c0392b"):strokewidth(0.5):evenly())
+pic:dotlabel("label", h.point(label.value, 25), "top", h.color("black"))
+pic:dotlabel("z", z:point(), "lft", h.color("#c0392b"))
+
+print(string.format("row length %.0f, label at %.0f, z = (%.0f, %.0f)",
+    left[#widths].value + widths[#widths], label.value, z.x, z.y))
+
+h.svg()
+    :padding(10)
+    :addpicture(pic)
+    :write("layout.svg")
+
+print("Created layout.svg")
@@ -0,0 +1,48 @@
+-- Custom pens from paths
+--
+-- h.makepen(path) turns the convex hull of the knots of a cyclic path into
+-- a polygonal pen, like MetaPost's makepen; h.makepath(pen) turns a pen
+-- back into its outline. Pens take the usual transformations.
+
+local h = require("hobby")
+
+local svg = h.svg():padding(10)
+
+-- A broad calligraphy nib, slightly rounded at one corner
+local nib = h.makepen(h.path()
+    :moveto(h.point(-3, -0.4))
+    :lineto(h.point(3, 0.4))
+    :lineto(h.point(3, 0.9))
+    :lineto(h.point(-2.6, 0.3))
+    :cycle()
+    :build())
+
+-- The same stroke with the nib held at three angles
+for i, angle in ipairs({ 0, 30, 60 }) do
+    local y = 60 - 30 * (i - 1)
+    svg:add(h.path()
+        :moveto(h.point(0, y))
+        :curveto(h.point(25, y + 15))
+        :curveto(h.point(50, y))
+        :curveto(h.point(75, y - 15))
+        :curveto(h.point(100, y))
+        :pen(nib:rotated(angle))
+        :stroke("#1f3b73")
+        :build())
+end
+
+-- The outlines of some pens, drawn with a thin line
+local pens = {
+    nib:scaled(3),
+    h.pencircle(12):xscaled(1.5):rotated(30),
+    h.pensquare(10):rotated(15),
+    h.makepen(h.polygon(6, 7)),
+}
+for i, pen in ipairs(pens) do
+    svg:add(h.makepath(pen):shifted(130 + 28 * (i - 1), 45)
+        :strokewidth(0.5):stroke("#c0392b"))
+end
+
+svg:write("makepen.svg")
+
+print("Created makepen.svg")
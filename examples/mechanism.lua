@@ -0,0 +1,74 @@
+-- Nonlinear constraints
+--
+-- ctx:distance, ctx:angle, ctx:perpendicular, ctx:parallel, ctx:onpath
+-- and ctx:tangent state constraints that are not linear. ctx:solve()
+-- solves them numerically, starting from the linear solution, the guesses
+-- given with ctx:guess() and the previous solution.
+
+local h = require("hobby")
+
+local pic = h.picture()
+
+-- A four-bar linkage: the crank a--b turns around a, the rocker d--c
+-- swings around d, and the coupler b--c connects them.
+local ctx = h.context()
+local a = ctx:known(0, 0, "a")
+local d = ctx:known(120, 0, "d")
+local b = ctx:known(40, 0, "b")
+local c = ctx:point("c")
+ctx:distance(b, c, 110)
+ctx:distance(d, c, 70)
+ctx:guess(c, 120, 70)
+local p = ctx:point("p")
+ctx:between(p, b, c, 0.5)
+
+local trace = h.path()
+for i = 0, 23 do
+    local t = i * 15
+    ctx:drag(b, 40 * math.cos(math.rad(t)), 40 * math.sin(math.rad(t)))
+    if i == 0 then
+        trace:moveto(p:point())
+    else
+        trace:curveto(p:point())
+    end
+    if i % 4 == 0 then
+        pic:add(h.path():moveto(a:point()):lineto(b:point()):lineto(c:point())
+            :lineto(d:point()):build():stroke("#85c1e9"):strokewidth(0.6))
+    end
+end
+pic:add(trace:cycle():build():stroke("#c0392b"):strokewidth(0.8))
+pic:dotlabel("a", a:point(), "bot", h.color("black"))
+pic:dotlabel("d", d:point(), "bot", h.color("black"))
+
+-- The tangents from q to a circle, and a right angle on the circle
+local circle = h.fullcircle():scaled(60):shifted(280, 30)
+local ctx2 = h.context()
+local q = ctx2:known(180, 30, "q")
+local t1, t2 = ctx2:point("t1"), ctx2:point("t2")
+ctx2:tangent(circle, t1, q)
+ctx2:tangent(circle, t2, q)
+ctx2:guess(t1, 280, 60)
+ctx2:guess(t2, 280, 0)
+local r = ctx2:point("r")
+ctx2:onpath(r, circle)
+ctx2:perpendicular(q, t1, t1, r)
+ctx2:guess(r, 290, 5)
+ctx2:solve()
+
+pic:add(circle:stroke("#1f3b73"):strokewidth(0.8))
+for _, t in ipairs({ t1, t2 }) do
+    pic:add(h.path():moveto(q:point()):lineto(t:point()):build()
+        :stroke("#1f3b73"):strokewidth(0.5))
+    pic:dotlabel(t.name, t:point(), t == t1 and "top" or "bot", h.color("black"))
+end
+pic:add(h.path():moveto(t1:point()):lineto(r:point()):build()
+    :stroke("#c0392b"):strokewidth(0.5))
+pic:dotlabel("q", q:point(), "lft", h.color("black"))
+pic:dotlabel("r", r:point(), "rt", h.color("#c0392b"))
+
+h.svg()
+    :padding(10)
+    :addpicture(pic)
+    :write("mechanism.svg")
+
+print("Created mechanism.svg")
@@ -0,0 +1,61 @@
+-- Numeric unknowns and linear equations
+--
+-- ctx:numeric() creates a scalar unknown and ctx:linear(terms, constant)
+-- states any linear equation between coordinates and numerics, so that
+-- point and numeric unknowns can be mixed like in MetaPost.
+
+local h = require("hobby")
+
+local ctx = h.context()
+
+-- Five boxes of given widths fill a line of length 200 with equal gaps.
+-- The gap is an unknown numeric.
+local widths = { 30, 20, 45, 25, 35 }
+local gap = ctx:numeric()
+local left = ctx:points(#widths)
+ctx:eq(left[1], h.point(0, 0))
+for i = 2, #widths do
+    -- x[i] - x[i-1] = width[i-1] + gap
+    ctx:linear({ { 1, left[i], "x" }, { -1, left[i - 1], "x" }, { -1, gap } }, widths[i - 1])
+    ctx:eqvary(left[i], left[i - 1])
+end
+-- the last box ends at 200
+ctx:linear({ { 1, left[#widths], "x" } }, 200 - widths[#widths])
+
+-- Below: z1 - z0 = a * (z3 - z2) with an unknown factor a, and
+-- x2 - x1 = 2 * (x3 - x2) for the points in between
+local z0 = ctx:known(0, -70)
+local z2 = ctx:known(0, -20)
+local z3 = ctx:known(40, -10)
+local z1 = ctx:unknown()
+local a = ctx:numeric()
+local dx, dy = z3.x - z2.x, z3.y - z2.y
+ctx:linear({ { 1, z1, "x" }, { -1, z0, "x" }, { -dx, a } }, 0)
+ctx:linear({ { 1, z1, "y" }, { -1, z0, "y" }, { -dy, a } }, 0)
+ctx:eqx(z1, 200)
+
+local p = ctx:unknown()
+ctx:linear({ { 1, p, "x" }, { -1, z0, "x" }, { -2, z1, "x" }, { 2, p, "x" } }, 0)
+ctx:collinear(p, z0, z1)
+
+ctx:solve()
+
+print(string.format("gap = %.2f, a = %.2f", gap.value, a.value))
+
+local pic = h.picture()
+for i, w in ipairs(widths) do
+    local x = left[i].x
+    pic:add(h.rect(x, 0, w, 20):fill("#dbe7f3"):stroke("#1f3b73"):strokewidth(0.5))
+end
+pic:add(h.path():moveto(z2:point()):lineto(z3:point()):arrow():build()
+    :stroke("gray"):strokewidth(0.5))
+pic:add(h.path():moveto(z0:point()):lineto(z1:point()):arrow():build()
+    :stroke("#c0392b"):strokewidth(0.8))
+pic:dotlabel("p", p:point(), "top", h.color("black"))
+
+h.svg()
+    :padding(10)
+    :addpicture(pic)
+    :write("numerics.svg")
+
+print("Created numerics.svg")
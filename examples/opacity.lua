@@ -0,0 +1,46 @@
+-- Opacity, transparency groups and blend modes
+--
+-- Colors can carry an opacity (h.rgba, "#rrggbbaa", "rgba(...)"), and
+-- fillopacity/strokeopacity set the opacity of whatever color a path is
+-- painted with. Pictures can be drawn as a transparency group with
+-- setopacity and blended with the drawing below them with setblend.
+
+local h = require("hobby")
+
+local function disk(x, y)
+    return h.fullcircle():scaled(40):shifted(x, y)
+end
+
+local svg = h.svg():padding(5)
+
+-- Venn diagram with translucent colors
+svg:add(disk(20, 30):fill(h.rgba(0.9, 0.2, 0.2, 0.5)):stroke("none"))
+svg:add(disk(40, 30):fill("#2060e080"):stroke("none"))
+svg:add(disk(30, 13):fill("rgba(40, 170, 60, 0.5)"):stroke("none"))
+
+-- The same with opaque colors and the multiply blend mode
+local venn = h.picture()
+local blended = h.style{ stroke = "none" }
+for _, d in ipairs{ { 20, 30, "#f08080" }, { 40, 30, "#80a8f0" }, { 30, 13, "#90e090" } } do
+    venn:add(disk(d[1] + 70, d[2]):fill(d[3]), { style = blended, blend = "multiply" })
+end
+svg:addpicture(venn)
+
+-- fillopacity on separate paths: the overlap shows through
+local chain = h.picture()
+for i = 0, 2 do
+    chain:add(h.fullcircle():scaled(20):shifted(15 * i + 10, -20):fill("darkorange")
+        :fillopacity(0.5):stroke("black"):strokeopacity(0.5))
+end
+svg:addpicture(chain)
+
+-- setopacity on the picture: composited as a whole, no inner overlaps
+local group = h.picture()
+for i = 0, 2 do
+    group:add(h.fullcircle():scaled(20):shifted(15 * i + 80, -20):fill("darkorange"):stroke("black"))
+end
+svg:addpicture(group:setopacity(0.5))
+
+svg:write("opacity.svg")
+
+print("Created opacity.svg")
@@ -0,0 +1,50 @@
+-- Palettes and colormaps
+--
+-- h.colormap(name) returns a function from 0-1 to a color; h.palette(name,
+-- n) returns n colors as a table. Sequential maps (viridis, magma, inferno,
+-- plasma) encode values, qualitative palettes (okabeito, tableau10, set2,
+-- dark2) encode categories. h.colorblindsafe checks a list of colors.
+
+local h = require("hobby")
+
+local svg = h.svg():padding(5)
+
+-- Sequential colormaps as continuous bars
+local maps = { "viridis", "magma", "inferno", "plasma" }
+for row, name in ipairs(maps) do
+    local cmap = h.colormap(name)
+    for i = 0, 47 do
+        svg:add(h.rect(i * 2.5, 100 - row * 10, 2.5, 8):fill(cmap(i / 47)):stroke("none"))
+    end
+end
+
+-- Qualitative palettes as swatches
+local qualitative = { "okabeito", "tableau10", "set2", "dark2" }
+for row, name in ipairs(qualitative) do
+    local colors = h.palette(name)
+    for i = 1, #colors do
+        svg:add(h.rect(130 + (i - 1) * 10, 100 - row * 10, 9, 8):fill(colors[i]):stroke("none"))
+    end
+    local ok, worst = h.colorblindsafe(colors)
+    print(string.format("%-10s colorblind safe: %-5s closest: %d/%d (%s, %.1f)",
+        name, tostring(ok), worst.i, worst.j, worst.deficiency, worst.distance))
+end
+
+-- A heat map of f(x, y) with viridis
+local viridis = h.colormap("viridis")
+for x = 0, 19 do
+    for y = 0, 9 do
+        local v = (math.sin(x / 3) * math.cos(y / 2.5) + 1) / 2
+        svg:add(h.rect(x * 6, y * 5, 6, 5):fill(viridis(v)):stroke("none"))
+    end
+end
+
+-- Categories from a palette
+local okabe = h.palette("okabeito", 5)
+for i = 1, 5 do
+    svg:add(h.fullcircle():scaled(8):shifted(130 + (i - 1) * 12, 25):fill(okabe[i]):stroke("none"))
+end
+
+svg:write("palettes.svg")
+
+print("Created palettes.svg")
@@ -0,0 +1,39 @@
+-- Picture and pen transformations: reusing a sub-figure
+
+local h = require("hobby")
+
+-- A sub-figure: an arrow with a label, clipped to a disc
+local sub = h.picture()
+sub:add(h.path()
+    :moveto(h.point(0, 0))
+    :lineto(h.point(30, 0))
+    :arrow()
+    :build()
+    :stroke("blue"))
+sub:add(h.fullcircle():scaled(6):fill("blue"))
+sub:label("v", h.point(15, 0), "top")
+
+local svg = h.svg():padding(10)
+
+-- Place rotated copies around a center
+for i = 0, 5 do
+    svg:addpicture(sub:rotated(60 * i):shifted(50, 50))
+end
+
+-- Slanted and scaled copies
+svg:addpicture(sub:slanted(0.5):shifted(110, 30))
+svg:addpicture(sub:scaled(1.5):shifted(110, 60))
+
+-- Pens can be transformed the same way
+local pen = h.pencircle(1):xscaled(4):rotated(45)
+local stroke = h.path()
+    :moveto(h.point(10, -10))
+    :curveto(h.point(60, 0))
+    :curveto(h.point(110, -10))
+    :build()
+    :pen(pen)
+svg:add(stroke)
+
+svg:write("picturetransforms.svg")
+
+print("Created picturetransforms.svg")
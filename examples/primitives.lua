@@ -0,0 +1,28 @@
+-- Shape primitives: rectangles, ellipses, arcs, polygons, stars,
+-- superellipses and spirals
+
+local h = require("hobby")
+
+local rect = h.rect(0, 0, 50, 30):stroke("black")
+local rounded = h.rect(60, 0, 50, 30, {radius = 8}):fill("lightblue"):stroke("blue")
+local ellipse = h.ellipse(h.point(145, 15), 25, 15):stroke("red")
+local arc = h.arc(h.point(200, 15), 15, 0, 225):stroke("green"):strokewidth(1.5)
+
+local hexagon = h.polygon(6, 20):shifted(20, -40):fill("khaki"):stroke("olive")
+local star = h.star(5, 22, 9):shifted(80, -40):fill("gold"):stroke("orange")
+local super = h.superellipse(h.point(145, -40), 25, 18, 0.85):stroke("purple")
+local spiral = h.spiral(h.point(200, -40), 2, 20, 2.5):stroke("teal")
+
+h.svg()
+    :padding(5)
+    :add(rect)
+    :add(rounded)
+    :add(ellipse)
+    :add(arc)
+    :add(hexagon)
+    :add(star)
+    :add(super)
+    :add(spiral)
+    :write("primitives.svg")
+
+print("Created primitives.svg")
@@ -0,0 +1,52 @@
+-- Picture introspection: restyle a finished picture
+--
+-- pic:items() walks all drawn objects in drawing order, like MetaPost's
+-- "for x within pic". Paths are the objects stored in the picture, so
+-- changing them restyles the picture in place.
+
+local h = require("hobby")
+
+-- A picture as it might come from a library function
+local function diagram()
+    local pic = h.picture()
+    for i = 0, 4 do
+        local c = h.fullcircle():scaled(16):shifted(30 * i, 0)
+        pic:add(c:fill("lightyellow"):stroke(i % 2 == 0 and "red" or "black"))
+    end
+    for i = 0, 3 do
+        pic:add(h.path()
+            :moveto(h.point(30 * i + 8, 0))
+            :lineto(h.point(30 * i + 22, 0))
+            :arrow()
+            :build()
+            :stroke("red"))
+    end
+    pic:label("start", h.point(0, -8), "bot")
+    return pic
+end
+
+local original = diagram()
+local restyled = diagram()
+
+-- Recolor all red strokes, make arrows thicker and print what was found
+local red = h.color("red")
+for item in restyled:items() do
+    local style = item.style
+    if style then
+        if style.stroke == red then
+            item.path:stroke("darkblue")
+        end
+        if style.arrows["end"] then
+            item.path:strokewidth(1.5)
+        end
+    end
+    print(item.type, style and tostring(style.stroke) or "")
+end
+
+h.svg()
+    :padding(5)
+    :addpicture(original)
+    :addpicture(restyled:shifted(0, -40))
+    :write("recolor.svg")
+
+print("Created recolor.svg")
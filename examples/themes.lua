@@ -0,0 +1,57 @@
+-- Styles and themes
+--
+-- h.style{...} bundles path attributes into one value. Pictures and items
+-- can refer to styles by role name; the theme given to the SVG document
+-- decides what the roles look like, so the same figure can be written in
+-- a light and a dark variant.
+
+local h = require("hobby")
+
+-- The house style: shared by all figures
+local house = h.theme{
+    default    = { stroke = "#222222", width = 0.8, join = "round" },
+    background = { fill = "white", stroke = "none" },
+    axis       = { stroke = "gray", width = 0.5, arrow = "end" },
+    grid       = { stroke = "#dddddd", width = 0.3, dash = "withdots" },
+    curve      = { stroke = "#1f5fa8", width = 1.5 },
+    marker     = { fill = "#e08a00", stroke = "none" },
+}
+
+local dark = house:with{
+    default    = { stroke = "#eeeeee", width = 0.8 },
+    background = { fill = "#1e1e24", stroke = "none" },
+    grid       = { stroke = "#444450", width = 0.3, dash = "withdots" },
+    curve      = { stroke = "#7fb5ff", width = 1.5 },
+}
+
+-- The figure only refers to roles
+local pic = h.picture()
+pic:add(h.rect(-10, -10, 120, 80), { style = "background" })
+for x = 10, 100, 10 do
+    pic:add(h.path():moveto(h.point(x, 0)):lineto(h.point(x, 60)):build(), { style = "grid" })
+end
+pic:add(h.path():moveto(h.point(0, 0)):lineto(h.point(105, 0)):build(), { style = "axis" })
+pic:add(h.path():moveto(h.point(0, 0)):lineto(h.point(0, 65)):build(), { style = "axis" })
+
+local curve = h.path():moveto(h.point(0, 5))
+for x = 10, 100, 10 do
+    curve:curveto(h.point(x, 30 + 25 * math.sin(x / 16)))
+end
+pic:add(curve:build(), { style = "curve" })
+
+local markers = h.picture():setstyle("marker")
+for x = 20, 100, 40 do
+    markers:add(h.fullcircle():scaled(4):shifted(x, 30 + 25 * math.sin(x / 16)))
+end
+pic:addpicture(markers)
+
+-- A path's own style wins over its role: one highlighted grid line
+local highlight = h.style{ stroke = "crimson", width = 0.8 }
+pic:add(h.path():moveto(h.point(60, 0)):lineto(h.point(60, 60)):build():withstyle(highlight),
+    { style = "grid" })
+
+local svg = h.svg():addpicture(pic)
+svg:theme(house):write("themes-light.svg")
+svg:theme(dark):write("themes-dark.svg")
+
+print("Created themes-light.svg and themes-dark.svg")
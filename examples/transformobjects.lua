@@ -0,0 +1,35 @@
+-- Transform objects: one transform reused for a group of paths
+
+local h = require("hobby")
+
+-- A small figure made of several paths
+local body = h.rect(0, 0, 20, 12, {radius = 2})
+local roof = h.path()
+    :moveto(h.point(-2, 12))
+    :lineto(h.point(10, 20))
+    :lineto(h.point(22, 12))
+    :build()
+local door = h.rect(8, 0, 4, 7)
+
+-- Each copy of the figure uses the same transform for all its parts
+local svg = h.svg():padding(5)
+local t = h.transform()
+local colors = {"black", "blue", "red", "green"}
+for i, color in ipairs(colors) do
+    for _, part in ipairs({body, roof, door}) do
+        svg:add(t:apply(part):stroke(color))
+    end
+    t = t * h.transform():rotatedaround(h.point(10, 0), -15):scaled(0.85):shifted(40, 0)
+end
+
+-- transformfrom: map a unit triangle onto an arbitrary one
+local tri = h.polygon(3, 10)
+local pts = tri:knots()
+local f = h.transformfrom(
+    pts[1].point, pts[2].point, pts[3].point,
+    h.point(0, -20), h.point(20, -50), h.point(60, -35))
+svg:add(tri:shifted(0, -35):stroke("gray"))
+svg:add(tri:transformed(f):fill("lightblue"):stroke("blue"))
+
+svg:write("transformobjects.svg")
+print("Created transformobjects.svg")
@@ -0,0 +1,353 @@
+package hobby
+
+import (
+	"fmt"
+
+	"github.com/boxesandglue/mpgo/mp"
+	lua "github.com/speedata/go-lua"
+)
+
+// solverExpr is a linear expression of the unknowns of a context, the
+// result of arithmetic on variables: z1 + z2, 2*z3, (a - b)/2. Pair
+// expressions have an x and a y part, numeric expressions only x. Numbers
+// and points are constant expressions without a context.
+type solverExpr struct {
+	ctx  *solverContext
+	pair bool
+	x, y *linExpr
+	// op and args tell how a derived expression was formed, see derive
+	op   func(args []*solverExpr) (*solverExpr, error)
+	args []*solverExpr
+}
+
+// registerExprMeta registers the metatable for expressions
+func registerExprMeta(l *lua.State) {
+	lua.NewMetaTable(l, "hobby.expr")
+	l.PushGoFunction(exprIndex)
+	l.SetField(-2, "__index")
+	setExprArith(l)
+	l.Pop(1)
+}
+
+// setExprArith sets the arithmetic metamethods of variables and
+// expressions, and __tostring, on the metatable at the top of the stack.
+func setExprArith(l *lua.State) {
+	l.PushGoFunction(exprAdd)
+	l.SetField(-2, "__add")
+	l.PushGoFunction(exprSub)
+	l.SetField(-2, "__sub")
+	l.PushGoFunction(exprMul)
+	l.SetField(-2, "__mul")
+	l.PushGoFunction(exprDiv)
+	l.SetField(-2, "__div")
+	l.PushGoFunction(exprUnm)
+	l.SetField(-2, "__unm")
+	l.PushGoFunction(exprToString)
+	l.SetField(-2, "__tostring")
+}
+
+// pushExpr pushes an expression as userdata
+func pushExpr(l *lua.State, e *solverExpr) {
+	l.PushUserData(e)
+	lua.SetMetaTableNamed(l, "hobby.expr")
+}
+
+// isSolverOperand reports whether the value at index is a variable or an
+// expression of a context.
+func isSolverOperand(l *lua.State, index int) bool {
+	switch l.ToUserData(index).(type) {
+	case *pointVar, *numericVar, *solverExpr:
+		return true
+	}
+	return false
+}
+
+// pointExpr returns the pair expression of the point variable p.
+func pointExpr(p *pointVar) *solverExpr {
+	x, y := newLinExpr(0), newLinExpr(0)
+	x.addTerm(p.x, 1)
+	y.addTerm(p.x+1, 1)
+	return &solverExpr{ctx: p.ctx, pair: true, x: x, y: y}
+}
+
+// checkExpr reads a number, point, variable or expression at index.
+func checkExpr(l *lua.State, index int) *solverExpr {
+	switch v := l.ToUserData(index).(type) {
+	case *solverExpr:
+		return v
+	case *pointVar:
+		return pointExpr(v)
+	case *numericVar:
+		x := newLinExpr(0)
+		x.addTerm(v.index, 1)
+		return &solverExpr{ctx: v.ctx, x: x}
+	case *mp.Point:
+		return &solverExpr{pair: true, x: newLinExpr(v.X), y: newLinExpr(v.Y)}
+	}
+	if l.IsNumber(index) {
+		return &solverExpr{x: newLinExpr(lua.CheckNumber(l, index))}
+	}
+	if l.IsTable(index) {
+		p := checkPoint(l, index)
+		return &solverExpr{pair: true, x: newLinExpr(p.X), y: newLinExpr(p.Y)}
+	}
+	lua.Errorf(l, "expected number, point, variable or expression at argument %d", index)
+	return nil
+}
+
+// exprContext returns the context of a and b, nil if both are constant.
+func exprContext(l *lua.State, a, b *solverExpr) *solverContext {
+	switch {
+	case a.ctx == nil:
+		return b.ctx
+	case b.ctx == nil || a.ctx == b.ctx:
+		return a.ctx
+	}
+	lua.Errorf(l, "cannot combine variables of different contexts")
+	return nil
+}
+
+// combine returns fa*a + fb*b.
+func combine(ctx *solverContext, a, b *solverExpr, fa, fb float64) *solverExpr {
+	part := func(x, y *linExpr) *linExpr {
+		e := newLinExpr(0)
+		e.addExpr(x, fa)
+		e.addExpr(y, fb)
+		e.prune()
+		return e
+	}
+	result := &solverExpr{ctx: ctx, pair: a.pair, x: part(a.x, b.x)}
+	if a.pair {
+		result.y = part(a.y, b.y)
+	}
+	return result
+}
+
+// scale returns s*e.
+func (e *solverExpr) scale(s float64) *solverExpr {
+	return combine(e.ctx, e, e, s, 0)
+}
+
+// derive returns the expression op forms from args. Products and
+// quotients depend on values that are known when they are formed, so the
+// expression remembers how it was formed and refresh can form it again
+// after the values have changed.
+func derive(op func(args []*solverExpr) (*solverExpr, error), args ...*solverExpr) (*solverExpr, error) {
+	e, err := op(args)
+	if err != nil {
+		return nil, err
+	}
+	e.op, e.args = op, args
+	return e, nil
+}
+
+// refresh forms e again from the current values.
+func (e *solverExpr) refresh() (*solverExpr, error) {
+	if e.op == nil {
+		return e, nil
+	}
+	args := make([]*solverExpr, len(e.args))
+	for i, a := range e.args {
+		var err error
+		if args[i], err = a.refresh(); err != nil {
+			return nil, err
+		}
+	}
+	return derive(e.op, args...)
+}
+
+// linear returns fa*a + fb*b as a derived expression.
+func linear(ctx *solverContext, a, b *solverExpr, fa, fb float64) *solverExpr {
+	e, _ := derive(func(args []*solverExpr) (*solverExpr, error) {
+		return combine(ctx, args[0], args[1], fa, fb), nil
+	}, a, b)
+	return e
+}
+
+// value returns the value of part x of e; ok is false while it has
+// unknowns.
+func (e *solverExpr) value(x *linExpr) (float64, bool) {
+	if e.ctx == nil {
+		return x.constant, true
+	}
+	return e.ctx.solver.valueOf(x)
+}
+
+// known returns the value of e if all of it is known.
+func (e *solverExpr) known() (x, y float64, ok bool) {
+	x, ok = e.value(e.x)
+	if ok && e.pair {
+		y, ok = e.value(e.y)
+	}
+	return x, y, ok
+}
+
+// checkSameKind raises an error unless a and b are both pairs or both
+// numerics.
+func checkSameKind(l *lua.State, a, b *solverExpr, op string) {
+	if a.pair != b.pair {
+		lua.Errorf(l, "cannot %s a pair and a numeric", op)
+	}
+}
+
+// exprAdd implements a + b.
+func exprAdd(l *lua.State) int {
+	a, b := checkExpr(l, 1), checkExpr(l, 2)
+	checkSameKind(l, a, b, "add")
+	pushExpr(l, linear(exprContext(l, a, b), a, b, 1, 1))
+	return 1
+}
+
+// exprSub implements a - b.
+func exprSub(l *lua.State) int {
+	a, b := checkExpr(l, 1), checkExpr(l, 2)
+	checkSameKind(l, a, b, "subtract")
+	pushExpr(l, linear(exprContext(l, a, b), a, b, 1, -1))
+	return 1
+}
+
+// product returns a * b. Like in MetaPost one factor must be known when
+// the product is formed, so that the result stays linear.
+func product(ctx *solverContext, a, b *solverExpr) (*solverExpr, error) {
+	if a.pair && b.pair {
+		return nil, fmt.Errorf("cannot multiply two pairs")
+	}
+	// a numeric factor that is known scales the other one
+	for _, f := range [][2]*solverExpr{{a, b}, {b, a}} {
+		if f[0].pair {
+			continue
+		}
+		if s, ok := f[0].value(f[0].x); ok {
+			result := f[1].scale(s)
+			result.ctx = ctx
+			return result, nil
+		}
+	}
+	// an unknown numeric times a known pair
+	n, p := a, b
+	if a.pair {
+		n, p = b, a
+	}
+	if p.pair {
+		if x, y, ok := p.known(); ok {
+			result := &solverExpr{ctx: ctx, pair: true, x: newLinExpr(0), y: newLinExpr(0)}
+			result.x.addExpr(n.x, x)
+			result.y.addExpr(n.x, y)
+			result.x.prune()
+			result.y.prune()
+			return result, nil
+		}
+	}
+	return nil, fmt.Errorf("nonlinear product: one factor must be known")
+}
+
+// quotient returns a / b for a known numeric b.
+func quotient(ctx *solverContext, a, b *solverExpr) (*solverExpr, error) {
+	s, ok := b.value(b.x)
+	if b.pair || !ok {
+		return nil, fmt.Errorf("can only divide by a known numeric")
+	}
+	if s == 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	result := a.scale(1 / s)
+	result.ctx = ctx
+	return result, nil
+}
+
+// exprMul implements a * b.
+func exprMul(l *lua.State) int {
+	a, b := checkExpr(l, 1), checkExpr(l, 2)
+	ctx := exprContext(l, a, b)
+	e, err := derive(func(args []*solverExpr) (*solverExpr, error) {
+		return product(ctx, args[0], args[1])
+	}, a, b)
+	if err != nil {
+		lua.Errorf(l, "%s", err.Error())
+		return 0
+	}
+	pushExpr(l, e)
+	return 1
+}
+
+// exprDiv implements a / b for a known numeric b.
+func exprDiv(l *lua.State) int {
+	a, b := checkExpr(l, 1), checkExpr(l, 2)
+	ctx := exprContext(l, a, b)
+	e, err := derive(func(args []*solverExpr) (*solverExpr, error) {
+		return quotient(ctx, args[0], args[1])
+	}, a, b)
+	if err != nil {
+		lua.Errorf(l, "%s", err.Error())
+		return 0
+	}
+	pushExpr(l, e)
+	return 1
+}
+
+// exprUnm implements -a.
+func exprUnm(l *lua.State) int {
+	a := checkExpr(l, 1)
+	pushExpr(l, linear(a.ctx, a, a, -1, 0))
+	return 1
+}
+
+func exprIndex(l *lua.State) int {
+	e := l.ToUserData(1).(*solverExpr)
+	key := lua.CheckString(l, 2)
+
+	switch key {
+	case "xpart", "ypart":
+		// e.xpart, e.ypart - the parts of a pair expression
+		if !e.pair {
+			lua.Errorf(l, "%s of a numeric expression", key)
+			return 0
+		}
+		ypart := key == "ypart"
+		part, _ := derive(func(args []*solverExpr) (*solverExpr, error) {
+			if ypart {
+				return &solverExpr{ctx: args[0].ctx, x: args[0].y}, nil
+			}
+			return &solverExpr{ctx: args[0].ctx, x: args[0].x}, nil
+		}, e)
+		pushExpr(l, part)
+		return 1
+
+	case "pair":
+		// e.pair - true for pair expressions
+		l.PushBoolean(e.pair)
+		return 1
+
+	case "value":
+		// e.value - the value (a number or a point), nil while unknown
+		x, y, ok := e.known()
+		if !ok {
+			return 0
+		}
+		if e.pair {
+			pushPoint(l, mp.P(x, y))
+		} else {
+			l.PushNumber(x)
+		}
+		return 1
+	}
+
+	return 0
+}
+
+// exprToString shows e in terms of the unknowns, e.g. "(z1.x + 10, z1.y)".
+func exprToString(l *lua.State) int {
+	e := checkExpr(l, 1)
+	format := func(x *linExpr) string {
+		if e.ctx == nil {
+			return formatNumber(x.constant)
+		}
+		return e.ctx.formatExpr(e.ctx.solver.reduce(x))
+	}
+	if e.pair {
+		l.PushString("(" + format(e.x) + ", " + format(e.y) + ")")
+	} else {
+		l.PushString(format(e.x))
+	}
+	return 1
+}
@@ -0,0 +1,188 @@
+package hobby
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/boxesandglue/mpgo/mp"
+	lua "github.com/speedata/go-lua"
+)
+
+// gradientPrefix marks colors that describe a gradient. mp.Style only has
+// room for an mp.Color, so a gradient is stored in the color's CSS text
+// and decoded again by the SVG writer.
+const gradientPrefix = "hobby-gradient:"
+
+// gradient is a linear or radial color gradient in user coordinates.
+// Linear gradients run from (X1, Y1) to (X2, Y2). Radial gradients run
+// from the circle (X1, Y1, R1) to the circle (X2, Y2, R2), like
+// MetaPost's (MetaFun's) circular shading. Transform maps the gradient
+// onto the drawing when the painted path has been transformed.
+type gradient struct {
+	Radial    bool
+	X1, Y1    float64
+	X2, Y2    float64
+	R1, R2    float64
+	Stops     []gradientStop
+	Transform mp.Transform
+}
+
+// gradientStop is a color at a position between 0 and 1.
+type gradientStop struct {
+	Offset  float64
+	Color   string
+	Opacity float64
+}
+
+// color returns the gradient encoded as a color.
+func (g *gradient) color() mp.Color {
+	data, _ := json.Marshal(g)
+	return mp.ColorCSS(gradientPrefix + string(data))
+}
+
+// gradientOf decodes the gradient stored in c. ok is false for ordinary
+// colors.
+func gradientOf(c mp.Color) (*gradient, bool) {
+	css := c.CSS()
+	if !strings.HasPrefix(css, gradientPrefix) {
+		return nil, false
+	}
+	g := &gradient{}
+	if err := json.Unmarshal([]byte(css[len(gradientPrefix):]), g); err != nil {
+		return nil, false
+	}
+	return g, true
+}
+
+// transformPaint returns c with its gradient or pattern transformed by t.
+// Ordinary colors are returned unchanged.
+func transformPaint(c mp.Color, t mp.Transform) mp.Color {
+	if g, ok := gradientOf(c); ok {
+		g.Transform = g.Transform.Then(t)
+		return g.color()
+	}
+	if pat, ok := patternOf(c); ok {
+		pat.Transform = pat.Transform.Then(t)
+		return pat.color()
+	}
+	return c
+}
+
+// transformPath returns a transformed copy of p. Gradients in the style
+// move along with the path. Like mp.Transform.ApplyToPath it returns nil
+// for an empty path.
+func transformPath(p *mp.Path, t mp.Transform) *mp.Path {
+	result := t.ApplyToPath(p)
+	if result == nil {
+		return nil
+	}
+	result.Style.Fill = transformPaint(result.Style.Fill, t)
+	result.Style.Stroke = transformPaint(result.Style.Stroke, t)
+	return result
+}
+
+// luaLinearGradient creates a linear gradient: h.lineargradient(p1, p2, stops)
+// The result can be used wherever a color is expected (fill, stroke).
+func luaLinearGradient(l *lua.State) int {
+	p1 := checkPoint(l, 1)
+	p2 := checkPoint(l, 2)
+	g := &gradient{
+		X1: p1.X, Y1: p1.Y,
+		X2: p2.X, Y2: p2.Y,
+		Stops:     checkGradientStops(l, 3),
+		Transform: mp.Identity(),
+	}
+	pushColor(l, g.color())
+	return 1
+}
+
+// luaRadialGradient creates a radial gradient: h.radialgradient(c1, r1, c2, r2, stops)
+// The colors run from the circle around c1 with radius r1 (offset 0) to the
+// circle around c2 with radius r2 (offset 1).
+func luaRadialGradient(l *lua.State) int {
+	c1 := checkPoint(l, 1)
+	r1 := lua.CheckNumber(l, 2)
+	c2 := checkPoint(l, 3)
+	r2 := lua.CheckNumber(l, 4)
+	if r1 < 0 || r2 < 0 {
+		lua.Errorf(l, "radialgradient: radii must not be negative")
+		return 0
+	}
+	g := &gradient{
+		Radial: true,
+		X1:     c1.X, Y1: c1.Y, R1: r1,
+		X2: c2.X, Y2: c2.Y, R2: r2,
+		Stops:     checkGradientStops(l, 5),
+		Transform: mp.Identity(),
+	}
+	pushColor(l, g.color())
+	return 1
+}
+
+// checkGradientStops reads the color stops at index. Entries are either
+// colors, which are spread evenly, or {offset, color} pairs:
+//
+//	{"white", "steelblue"}
+//	{{0, "white"}, {0.3, "lightblue"}, {1, "navy"}}
+func checkGradientStops(l *lua.State, index int) []gradientStop {
+	lua.CheckType(l, index, lua.TypeTable)
+	n := l.RawLength(index)
+	if n < 2 {
+		lua.Errorf(l, "a gradient needs at least two color stops")
+		return nil
+	}
+	stops := make([]gradientStop, n)
+	for i := 1; i <= n; i++ {
+		l.RawGetInt(index, i)
+		entry := l.Top()
+		offset := float64(i-1) / float64(n-1)
+		var c mp.Color
+		if isOffsetStop(l, entry) {
+			l.RawGetInt(entry, 1)
+			offset, _ = l.ToNumber(-1)
+			l.RawGetInt(entry, 2)
+			c = checkColor(l, l.Top())
+			l.Pop(2)
+		} else {
+			c = checkColor(l, entry)
+		}
+		l.Pop(1)
+		_, isGradient := gradientOf(c)
+		if _, isPattern := patternOf(c); isGradient || isPattern {
+			lua.Errorf(l, "gradient stops must be colors")
+			return nil
+		}
+		stop := gradientStop{Offset: offset, Color: displayColor(c).CSS(), Opacity: 1}
+		if op, ok := colorOpacity(c); ok {
+			stop.Opacity = op
+		}
+		stops[i-1] = stop
+	}
+	return stops
+}
+
+// isOffsetStop reports whether the table at index is an {offset, color}
+// pair (and not an {r, g, b} color).
+func isOffsetStop(l *lua.State, index int) bool {
+	if !l.IsTable(index) {
+		return false
+	}
+	l.RawGetInt(index, 2)
+	defer l.Pop(1)
+	return !l.IsNil(-1) && !l.IsNumber(-1)
+}
+
+// gradientString describes a gradient for tostring().
+func gradientString(g *gradient) string {
+	var stops []string
+	for _, s := range g.Stops {
+		stops = append(stops, fmt.Sprintf("%g %s", s.Offset, s.Color))
+	}
+	if g.Radial {
+		return fmt.Sprintf("radialgradient((%g,%g) %g, (%g,%g) %g; %s)",
+			g.X1, g.Y1, g.R1, g.X2, g.Y2, g.R2, strings.Join(stops, ", "))
+	}
+	return fmt.Sprintf("lineargradient((%g,%g), (%g,%g); %s)",
+		g.X1, g.Y1, g.X2, g.Y2, strings.Join(stops, ", "))
+}
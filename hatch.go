@@ -0,0 +1,132 @@
+package hobby
+
+import (
+	"math"
+	"sort"
+
+	"github.com/boxesandglue/mpgo/mp"
+	lua "github.com/speedata/go-lua"
+)
+
+// hatchSamples is the number of line pieces a curved segment is split into
+// when the outline of a hatched region is flattened.
+const hatchSamples = 32
+
+// flattenCycle returns the outline of the cyclic path p as a polygon.
+// Straight segments keep their two end points.
+func flattenCycle(p *mp.Path) []mp.Point {
+	var pts []mp.Point
+	for _, k := range pathKnots(p) {
+		q := k.Next
+		pts = append(pts, mp.P(k.XCoord, k.YCoord))
+		if isStraightSegment(k, q) {
+			continue
+		}
+		for i := 1; i < hatchSamples; i++ {
+			t := float64(i) / hatchSamples
+			u := 1 - t
+			a, b, c, d := u*u*u, 3*u*u*t, 3*u*t*t, t*t*t
+			pts = append(pts, mp.P(
+				a*k.XCoord+b*k.RightX+c*q.LeftX+d*q.XCoord,
+				a*k.YCoord+b*k.RightY+c*q.LeftY+d*q.YCoord))
+		}
+	}
+	return pts
+}
+
+// isStraightSegment reports whether the control points of the segment from
+// k to q lie on the line between k and q.
+func isStraightSegment(k, q *mp.Knot) bool {
+	dx, dy := q.XCoord-k.XCoord, q.YCoord-k.YCoord
+	length := math.Hypot(dx, dy)
+	if length == 0 {
+		return k.RightX == k.XCoord && k.RightY == k.YCoord && q.LeftX == q.XCoord && q.LeftY == q.YCoord
+	}
+	dist := func(x, y float64) float64 {
+		return math.Abs((x-k.XCoord)*dy-(y-k.YCoord)*dx) / length
+	}
+	const eps = 1e-6
+	return dist(k.RightX, k.RightY) < eps && dist(q.LeftX, q.LeftY) < eps
+}
+
+// hatchLines returns the hatch lines of the region inside the cyclic path
+// p: lines at angle degrees, spacing apart and shifted by offset across
+// their direction, cut where they leave the region. The inside is
+// determined by the nonzero winding rule, like an SVG fill.
+func hatchLines(p *mp.Path, angle, spacing, offset float64) [][2]mp.Point {
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	// Rotate the outline so that the hatch lines are horizontal.
+	outline := flattenCycle(p)
+	rotated := make([]mp.Point, len(outline))
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	for i, pt := range outline {
+		rotated[i] = mp.P(pt.X*cos+pt.Y*sin, -pt.X*sin+pt.Y*cos)
+		minY, maxY = math.Min(minY, rotated[i].Y), math.Max(maxY, rotated[i].Y)
+	}
+	type crossing struct {
+		x       float64
+		winding int
+	}
+	var lines [][2]mp.Point
+	unrotate := func(x, y float64) mp.Point {
+		return mp.P(x*cos-y*sin, x*sin+y*cos)
+	}
+	for i := math.Ceil((minY - offset) / spacing); offset+i*spacing <= maxY; i++ {
+		y := offset + i*spacing
+		var crossings []crossing
+		for j, a := range rotated {
+			b := rotated[(j+1)%len(rotated)]
+			if a.Y == b.Y || y < math.Min(a.Y, b.Y) || y >= math.Max(a.Y, b.Y) {
+				continue
+			}
+			c := crossing{x: a.X + (y-a.Y)/(b.Y-a.Y)*(b.X-a.X), winding: 1}
+			if b.Y < a.Y {
+				c.winding = -1
+			}
+			crossings = append(crossings, c)
+		}
+		sort.Slice(crossings, func(a, b int) bool { return crossings[a].x < crossings[b].x })
+		winding := 0
+		var start float64
+		for _, c := range crossings {
+			if winding == 0 {
+				start = c.x
+			}
+			winding += c.winding
+			if winding == 0 && c.x > start {
+				lines = append(lines, [2]mp.Point{unrotate(start, y), unrotate(c.x, y)})
+			}
+		}
+	}
+	return lines
+}
+
+// hatchPicture returns a picture with the hatch lines of p, styled with s.
+func hatchPicture(p *mp.Path, angle, spacing, offset float64, s styleSpec) *picture {
+	pic := newPicture()
+	for _, line := range hatchLines(p, angle, spacing, offset) {
+		seg := &shapePath{}
+		seg.moveTo(line[0])
+		seg.lineTo(line[1])
+		buildStyled(seg.path, s)
+		pic.addPath(seg.path)
+	}
+	return pic
+}
+
+// checkHatch reads the options of path:hatch{angle=, spacing=, offset=,
+// stroke=, width=, pen=, ...} at index. All keys are optional; the style
+// keys are those of h.style.
+func checkHatch(l *lua.State, index int) (angle, spacing, offset float64, s styleSpec) {
+	angle, spacing = 45, 3
+	if l.IsNoneOrNil(index) {
+		return angle, spacing, 0, s
+	}
+	angle = optNumberField(l, index, "angle", angle)
+	spacing = optNumberField(l, index, "spacing", spacing)
+	offset = optNumberField(l, index, "offset", 0)
+	if spacing <= 0 {
+		lua.Errorf(l, "hatch: spacing must be positive")
+	}
+	return angle, spacing, offset, checkStyleTable(l, index, "hatch", "angle", "spacing", "offset")
+}
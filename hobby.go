@@ -3,7 +3,6 @@ package hobby
 
 import (
 	"github.com/boxesandglue/mpgo/mp"
-	"github.com/boxesandglue/mpgo/svg"
 	lua "github.com/speedata/go-lua"
 )
 
@@ -16,6 +15,8 @@ func Open(l *lua.State) {
 	// Register metatables first (needed before any hobby objects are created)
 	registerPointMeta(l)
 	registerPathMeta(l)
+	registerKnotMeta(l)
+	registerTransformMeta(l)
 	registerSVGMeta(l)
 	registerColorMeta(l)
 	registerPenMeta(l)
@@ -24,8 +25,10 @@ func Open(l *lua.State) {
 	registerLabelMeta(l)
 	registerContextMeta(l)
 	registerVarMeta(l)
+	registerExprMeta(l)
 	registerCtxPathBuilderMeta(l)
 	registerFaceMeta(l)
+	registerStyleMeta(l)
 
 	// Register the module loader in package.preload
 	l.Field(lua.RegistryIndex, "_PRELOAD")
@@ -59,6 +62,9 @@ func loader(l *lua.State) int {
 	l.PushGoFunction(luaNewPath)
 	l.SetField(-2, "path")
 
+	l.PushGoFunction(luaInterpolate)
+	l.SetField(-2, "interpolate")
+
 	// Predefined paths
 	l.PushGoFunction(luaFullCircle)
 	l.SetField(-2, "fullcircle")
@@ -72,6 +78,52 @@ func loader(l *lua.State) int {
 	l.PushGoFunction(luaUnitSquare)
 	l.SetField(-2, "unitsquare")
 
+	// Shapes
+	l.PushGoFunction(luaRect)
+	l.SetField(-2, "rect")
+
+	l.PushGoFunction(luaEllipse)
+	l.SetField(-2, "ellipse")
+
+	l.PushGoFunction(luaArc)
+	l.SetField(-2, "arc")
+
+	l.PushGoFunction(luaPolygon)
+	l.SetField(-2, "polygon")
+
+	l.PushGoFunction(luaStar)
+	l.SetField(-2, "star")
+
+	l.PushGoFunction(luaSuperellipse)
+	l.SetField(-2, "superellipse")
+
+	l.PushGoFunction(luaSpiral)
+	l.SetField(-2, "spiral")
+
+	// Transforms
+	l.PushGoFunction(luaTransform)
+	l.SetField(-2, "transform")
+
+	l.PushGoFunction(luaTransformFrom)
+	l.SetField(-2, "transformfrom")
+
+	// Styles
+	l.PushGoFunction(luaStyle)
+	l.SetField(-2, "style")
+
+	l.PushGoFunction(luaTheme)
+	l.SetField(-2, "theme")
+
+	// Gradients and patterns
+	l.PushGoFunction(luaLinearGradient)
+	l.SetField(-2, "lineargradient")
+
+	l.PushGoFunction(luaRadialGradient)
+	l.SetField(-2, "radialgradient")
+
+	l.PushGoFunction(luaPattern)
+	l.SetField(-2, "pattern")
+
 	// SVG output
 	l.PushGoFunction(luaNewSVG)
 	l.SetField(-2, "svg")
@@ -80,12 +132,43 @@ func loader(l *lua.State) int {
 	l.PushGoFunction(luaColorRGB)
 	l.SetField(-2, "rgb")
 
+	l.PushGoFunction(luaColorRGBA)
+	l.SetField(-2, "rgba")
+
 	l.PushGoFunction(luaColorGray)
 	l.SetField(-2, "gray")
 
 	l.PushGoFunction(luaColorCSS)
 	l.SetField(-2, "color")
 
+	l.PushGoFunction(luaColorCMYK)
+	l.SetField(-2, "cmyk")
+
+	l.PushGoFunction(luaColorSpot)
+	l.SetField(-2, "spot")
+
+	l.PushGoFunction(luaColorHSL)
+	l.SetField(-2, "hsl")
+
+	l.PushGoFunction(luaColorHSV)
+	l.SetField(-2, "hsv")
+
+	l.PushGoFunction(luaColorLab)
+	l.SetField(-2, "lab")
+
+	l.PushGoFunction(luaMix)
+	l.SetField(-2, "mix")
+
+	// Palettes
+	l.PushGoFunction(luaPalette)
+	l.SetField(-2, "palette")
+
+	l.PushGoFunction(luaColormap)
+	l.SetField(-2, "colormap")
+
+	l.PushGoFunction(luaColorblindSafe)
+	l.SetField(-2, "colorblindsafe")
+
 	// Pen constructors
 	l.PushGoFunction(luaPenCircle)
 	l.SetField(-2, "pencircle")
@@ -99,6 +182,12 @@ func loader(l *lua.State) int {
 	l.PushGoFunction(luaPenSpeck)
 	l.SetField(-2, "penspeck")
 
+	l.PushGoFunction(luaMakePen)
+	l.SetField(-2, "makepen")
+
+	l.PushGoFunction(luaMakePath)
+	l.SetField(-2, "makepath")
+
 	// Dash pattern constructors
 	l.PushGoFunction(luaDashEvenly)
 	l.SetField(-2, "evenly")
@@ -171,17 +260,41 @@ func checkPath(l *lua.State, index int) *mp.Path {
 }
 
 // Helper to push an SVG builder as userdata
-func pushSVG(l *lua.State, s *svg.Builder) {
+func pushSVG(l *lua.State, s *svgDocument) {
 	l.PushUserData(s)
 	lua.SetMetaTableNamed(l, "hobby.svg")
 }
 
 // Helper to check if value at index is an SVG builder
-func checkSVG(l *lua.State, index int) *svg.Builder {
+func checkSVG(l *lua.State, index int) *svgDocument {
 	ud := l.ToUserData(index)
-	if s, ok := ud.(*svg.Builder); ok {
+	if s, ok := ud.(*svgDocument); ok {
 		return s
 	}
 	lua.Errorf(l, "expected svg at argument %d", index)
 	return nil
 }
+
+// optNumberField reads an optional numeric field from the options table at
+// index. If there is no table or no such field, def is returned.
+func optNumberField(l *lua.State, index int, name string, def float64) float64 {
+	if !l.IsTable(index) {
+		return def
+	}
+	l.Field(index, name)
+	v := lua.OptNumber(l, -1, def)
+	l.Pop(1)
+	return v
+}
+
+// optStringField reads an optional string field from the options table at
+// index. If there is no table or no such field, "" is returned.
+func optStringField(l *lua.State, index int, name string) string {
+	if !l.IsTable(index) {
+		return ""
+	}
+	l.Field(index, name)
+	v := lua.OptString(l, -1, "")
+	l.Pop(1)
+	return v
+}
@@ -0,0 +1,412 @@
+package hobby
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	lua "github.com/speedata/go-lua"
+)
+
+// Inequalities and objectives turn the linear equations into a linear
+// program. Like the nonlinear constraints they are collected while the
+// calls are made and solved by ctx:solve(): the unknowns that the
+// equations leave independent and that appear in an inequality or in the
+// objective get the values of an optimal solution of the linear program,
+// found with the simplex method, and are then fixed by equations.
+// Unknowns without an objective take the values of some feasible point.
+// The nonlinear constraints are solved first, together with the
+// inequalities that share unknowns with them, see settle; the unknowns
+// they fix are constants in the linear program. An objective on the
+// unknowns they leave open is an error.
+
+// inequality is the constraint e >= 0.
+type inequality struct {
+	record equationRecord
+	e      *linExpr
+}
+
+// objectiveTerm is an expression given to ctx:minimize() or
+// ctx:maximize(), as it is minimized.
+type objectiveTerm struct {
+	call equationCall
+	e    *linExpr
+}
+
+// lpStatus is the outcome of the simplex method.
+type lpStatus int
+
+const (
+	lpOptimal lpStatus = iota
+	lpInfeasible
+	lpUnbounded
+)
+
+// simplexEps is the tolerance of the simplex method.
+const simplexEps = 1e-9
+
+// constrainInequality makes the constraint call kind: a >= b.
+func (c *solverContext) constrainInequality(l *lua.State, kind string, a, b *solverExpr) {
+	err := c.constrain(l, kind, solverOp{run: func(*solverOp) error {
+		diff, err := linear(c, a, b, 1, -1).refresh()
+		if err != nil {
+			return err
+		}
+		add := func(e *linExpr, part string) {
+			c.inequalities = append(c.inequalities, inequality{
+				record: equationRecord{equationCall: c.call, part: part},
+				e:      e,
+			})
+		}
+		if diff.pair {
+			add(diff.x, "x")
+			add(diff.y, "y")
+		} else {
+			add(diff.x, "")
+		}
+		return nil
+	}})
+	if err != nil {
+		lua.Errorf(l, "%s error: %s", kind, err.Error())
+	}
+}
+
+// splitInequalities separates the inequalities that share unknowns with
+// the nonlinear constraints, directly or through other such inequalities,
+// from those of the linear program. source maps the unknowns they share to
+// the unknown of a nonlinear constraint that couples them.
+func (c *solverContext) splitInequalities() (linear, coupled []inequality, source map[int]int) {
+	source = map[int]int{}
+	for _, nc := range c.nonlinear {
+		for _, e := range nc.exprs {
+			for _, i := range c.solver.reduce(e).unknowns() {
+				source[i] = i
+			}
+		}
+	}
+	linear = c.inequalities
+	for changed := len(source) > 0; changed; {
+		changed = false
+		var rest []inequality
+		for _, ineq := range linear {
+			unknowns := c.solver.reduce(ineq.e).unknowns()
+			from, shared := 0, false
+			for _, i := range unknowns {
+				if s, ok := source[i]; ok && !shared {
+					from, shared = s, true
+				}
+			}
+			if !shared {
+				rest = append(rest, ineq)
+				continue
+			}
+			coupled = append(coupled, ineq)
+			for _, i := range unknowns {
+				if _, ok := source[i]; !ok {
+					source[i] = from
+					changed = true
+				}
+			}
+		}
+		linear = rest
+	}
+	return linear, coupled, source
+}
+
+// checkObjective returns an error if the objective involves unknowns that
+// are coupled to the nonlinear constraints, see splitInequalities.
+func (c *solverContext) checkObjective(source map[int]int) error {
+	for _, o := range c.objective {
+		for _, i := range c.solver.reduce(o.e).unknowns() {
+			if s, ok := source[i]; ok {
+				return fmt.Errorf("%s cannot be combined with the nonlinear constraints on %s",
+					equationRecord{equationCall: o.call}.describe(), c.unknownName(s))
+			}
+		}
+	}
+	return nil
+}
+
+// optimize solves the linear program of the inequalities and the
+// objective and fixes the unknowns it involves.
+func (c *solverContext) optimize(inequalities []inequality) error {
+	param := map[int]int{}
+	var unknowns []int
+	reduce := func(e *linExpr) *linExpr {
+		r := c.solver.reduce(e)
+		for _, i := range r.unknowns() {
+			if _, ok := param[i]; !ok {
+				param[i] = len(unknowns)
+				unknowns = append(unknowns, i)
+			}
+		}
+		return r
+	}
+	var rows []*linExpr
+	var involved []inequality
+	for _, ineq := range inequalities {
+		r := reduce(ineq.e)
+		if len(r.terms) == 0 {
+			if r.constant < -solverTolerance {
+				return fmt.Errorf("%s is violated by %s", ineq.record.describe(), formatNumber(-r.constant))
+			}
+			continue
+		}
+		rows = append(rows, r)
+		involved = append(involved, ineq)
+	}
+	objective := newLinExpr(0)
+	for _, o := range c.objective {
+		objective.addExpr(reduce(o.e), 1)
+	}
+	objective.prune()
+	if len(unknowns) == 0 {
+		return nil
+	}
+
+	// Every unknown u is free, so it is written as u = p - q with p, q >= 0;
+	// e >= 0 becomes -e + constant <= constant.
+	n := len(unknowns)
+	a := make([][]float64, len(rows))
+	b := make([]float64, len(rows))
+	for k, r := range rows {
+		a[k] = make([]float64, 2*n)
+		for i, coef := range r.terms {
+			a[k][param[i]] = -coef
+			a[k][n+param[i]] = coef
+		}
+		b[k] = r.constant
+	}
+	cost := make([]float64, 2*n)
+	for i, coef := range objective.terms {
+		cost[param[i]] = coef
+		cost[n+param[i]] = -coef
+	}
+	x, status := simplex(a, b, cost)
+	switch status {
+	case lpInfeasible:
+		var desc []string
+		for _, ineq := range involved {
+			desc = append(desc, ineq.record.describe())
+		}
+		const shown = 4
+		list := strings.Join(desc[:min(len(desc), shown)], ", ")
+		if len(desc) > shown {
+			list += fmt.Sprintf(" and %d more", len(desc)-shown)
+		}
+		return fmt.Errorf("the inequalities cannot all be satisfied: %s", list)
+	case lpUnbounded:
+		return fmt.Errorf("the objective is unbounded")
+	}
+
+	c.call = equationCall{kind: "solve"}
+	for j, i := range unknowns {
+		e := newLinExpr(-(x[j] - x[n+j]))
+		e.addTerm(i, 1)
+		c.equate(e, "")
+	}
+	return nil
+}
+
+// simplex minimizes cost x subject to a x <= b and x >= 0 with the
+// two-phase simplex method.
+func simplex(a [][]float64, b, cost []float64) ([]float64, lpStatus) {
+	m, n := len(a), len(cost)
+	// Each row gets a slack variable; rows with a negative right hand
+	// side are negated and get an artificial variable to start from.
+	artificial := 0
+	for _, bi := range b {
+		if bi < 0 {
+			artificial++
+		}
+	}
+	cols := n + m + artificial
+	t := make([][]float64, m+1)
+	basis := make([]int, m)
+	next := n + m
+	for i := 0; i < m; i++ {
+		row := make([]float64, cols+1)
+		sign := 1.0
+		if b[i] < 0 {
+			sign = -1
+		}
+		for j := 0; j < n; j++ {
+			row[j] = sign * a[i][j]
+		}
+		row[n+i] = sign
+		row[cols] = sign * b[i]
+		basis[i] = n + i
+		if sign < 0 {
+			row[next] = 1
+			basis[i] = next
+			next++
+		}
+		t[i] = row
+	}
+	// The last row holds the reduced costs and minus the objective value.
+	obj := make([]float64, cols+1)
+	t[m] = obj
+
+	// phase 1: minimize the sum of the artificial variables
+	if artificial > 0 {
+		for j := n + m; j < cols; j++ {
+			obj[j] = 1
+		}
+		for i := 0; i < m; i++ {
+			if basis[i] >= n+m {
+				for j := range obj {
+					obj[j] -= t[i][j]
+				}
+			}
+		}
+		simplexPivots(t, basis, cols)
+		if -obj[cols] > 1e-7 {
+			return nil, lpInfeasible
+		}
+		// artificial variables left in the basis are 0; replace them
+		for i := 0; i < m; i++ {
+			if basis[i] < n+m {
+				continue
+			}
+			for j := 0; j < n+m; j++ {
+				if math.Abs(t[i][j]) > simplexEps {
+					simplexPivot(t, basis, i, j)
+					break
+				}
+			}
+		}
+	}
+
+	// phase 2: the objective, without the artificial variables
+	for j := range obj {
+		obj[j] = 0
+	}
+	copy(obj, cost)
+	for i := 0; i < m; i++ {
+		if bj := basis[i]; bj < n && cost[bj] != 0 {
+			for j := range obj {
+				obj[j] -= cost[bj] * t[i][j]
+			}
+		}
+	}
+	if !simplexPivots(t, basis, n+m) {
+		return nil, lpUnbounded
+	}
+	x := make([]float64, n)
+	for i, bj := range basis {
+		if bj < n {
+			x[bj] = t[i][cols]
+		}
+	}
+	return x, lpOptimal
+}
+
+// simplexPivots pivots until the reduced costs of the first columns are
+// not negative. It returns false if the objective is unbounded. Bland's
+// rule prevents cycling.
+func simplexPivots(t [][]float64, basis []int, columns int) bool {
+	m := len(basis)
+	obj := t[m]
+	rhs := len(obj) - 1
+	for {
+		enter := -1
+		for j := 0; j < columns; j++ {
+			if obj[j] < -simplexEps {
+				enter = j
+				break
+			}
+		}
+		if enter < 0 {
+			return true
+		}
+		leave := -1
+		var best float64
+		for i := 0; i < m; i++ {
+			if t[i][enter] <= simplexEps {
+				continue
+			}
+			ratio := t[i][rhs] / t[i][enter]
+			if leave < 0 || ratio < best-simplexEps || (ratio < best+simplexEps && basis[i] < basis[leave]) {
+				leave, best = i, ratio
+			}
+		}
+		if leave < 0 {
+			return false
+		}
+		simplexPivot(t, basis, leave, enter)
+	}
+}
+
+// simplexPivot makes column j basic in row i.
+func simplexPivot(t [][]float64, basis []int, i, j int) {
+	p := t[i][j]
+	for k := range t[i] {
+		t[i][k] /= p
+	}
+	for r := range t {
+		if r == i || t[r][j] == 0 {
+			continue
+		}
+		f := t[r][j]
+		for k := range t[r] {
+			t[r][k] -= f * t[i][k]
+		}
+	}
+	basis[i] = j
+}
+
+// inequalityIndex returns the inequality and objective methods of
+// contexts.
+func inequalityIndex(l *lua.State, c *solverContext, key string) int {
+	switch key {
+	case "ge", "le":
+		// ctx:ge(a, b), ctx:le(a, b) - constrain a >= b or a <= b; pairs
+		// are compared coordinatewise, so ctx:ge(z, h.point(0, 0)) keeps z
+		// in the first quadrant
+		l.PushGoFunction(func(l *lua.State) int {
+			a := checkExpr(l, 2)
+			b := checkExpr(l, 3)
+			if ctx := exprContext(l, a, b); ctx != nil && ctx != c {
+				lua.Errorf(l, "%s: the variables belong to another context", key)
+				return 0
+			}
+			checkSameKind(l, a, b, "compare")
+			if key == "le" {
+				a, b = b, a
+			}
+			c.constrainInequality(l, key, a, b)
+			l.PushValue(1)
+			return 1
+		})
+		return 1
+
+	case "minimize", "maximize":
+		// ctx:minimize(e), ctx:maximize(e) - the numeric expression to make
+		// as small or as large as the inequalities allow; several
+		// objectives are added. Unknowns that the nonlinear constraints
+		// leave open cannot be optimized.
+		l.PushGoFunction(func(l *lua.State) int {
+			e := checkCtxExpr(l, c, 2, false)
+			sign := 1.0
+			if key == "maximize" {
+				sign = -1
+			}
+			err := c.constrain(l, key, solverOp{run: func(*solverOp) error {
+				e, err := e.refresh()
+				if err != nil {
+					return err
+				}
+				c.objective = append(c.objective, objectiveTerm{call: c.call, e: e.scale(sign).x})
+				return nil
+			}})
+			if err != nil {
+				lua.Errorf(l, "%s error: %s", key, err.Error())
+				return 0
+			}
+			l.PushValue(1)
+			return 1
+		})
+		return 1
+	}
+	return 0
+}
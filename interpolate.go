@@ -0,0 +1,145 @@
+package hobby
+
+import (
+	"errors"
+	"math"
+
+	"github.com/boxesandglue/mpgo/mp"
+	lua "github.com/speedata/go-lua"
+)
+
+var (
+	errEmptyPath     = errors.New("empty path")
+	errCycleMismatch = errors.New("cannot blend a cyclic and a non-cyclic path")
+)
+
+// luaInterpolate blends two paths knot by knot: h.interpolate(a, b, t)
+// t=0 gives a, t=1 gives b. Knots are inserted into the path with fewer
+// segments until both have the same length.
+func luaInterpolate(l *lua.State) int {
+	a := checkPath(l, 1)
+	b := checkPath(l, 2)
+	t := lua.CheckNumber(l, 3)
+	result, err := interpolatePaths(a, b, t)
+	if err != nil {
+		lua.Errorf(l, "interpolate: %s", err.Error())
+		return 0
+	}
+	pushPath(l, result)
+	return 1
+}
+
+// interpolatePaths returns a new path blended between a and b at time t,
+// including control points and style.
+func interpolatePaths(a, b *mp.Path, t float64) (*mp.Path, error) {
+	if a == nil || a.Head == nil || b == nil || b.Head == nil {
+		return nil, errEmptyPath
+	}
+	if isCycle(a) != isCycle(b) {
+		return nil, errCycleMismatch
+	}
+	a, b = a.Copy(), b.Copy()
+	matchKnots(a, b)
+	if isCycle(a) {
+		b = alignCycle(a, b)
+	}
+
+	lerp := func(x, y float64) float64 { return x + t*(y-x) }
+	ka, kb := pathKnots(a), pathKnots(b)
+	result := mp.NewPath()
+	for i := range ka {
+		k := mp.CopyKnot(ka[i])
+		k.XCoord, k.YCoord = lerp(ka[i].XCoord, kb[i].XCoord), lerp(ka[i].YCoord, kb[i].YCoord)
+		k.LeftX, k.LeftY = lerp(ka[i].LeftX, kb[i].LeftX), lerp(ka[i].LeftY, kb[i].LeftY)
+		k.RightX, k.RightY = lerp(ka[i].RightX, kb[i].RightX), lerp(ka[i].RightY, kb[i].RightY)
+		result.Append(k)
+	}
+	result.Style = interpolateStyle(a.Style, b.Style, t)
+	return result, nil
+}
+
+// matchKnots splits segments of the shorter path until both paths have the
+// same number of segments. The longest remaining segment (by arc length)
+// is always split at its midpoint, which keeps the distribution of knots
+// even and leaves the shape of the path untouched.
+func matchKnots(a, b *mp.Path) {
+	for a.PathLength() < b.PathLength() {
+		splitLongestSegment(a)
+	}
+	for b.PathLength() < a.PathLength() {
+		splitLongestSegment(b)
+	}
+}
+
+// splitLongestSegment inserts a knot in the middle of the longest segment.
+func splitLongestSegment(p *mp.Path) {
+	n := p.PathLength()
+	if n == 0 {
+		// A single point: duplicate it so there is a segment to work with.
+		k := mp.CopyKnot(p.Head)
+		k.LType = mp.KnotExplicit
+		p.Head.RType = mp.KnotExplicit
+		p.Append(k)
+		return
+	}
+	knots := pathKnots(p)
+	longest, maxLen := 0, -1.0
+	for i := 0; i < n; i++ {
+		if l := p.ArcLengthSegment(i); l > maxLen {
+			longest, maxLen = i, l
+		}
+	}
+	splitKnot(knots[longest], 0.5)
+}
+
+// alignCycle picks the start knot and orientation of the cyclic path b
+// that lie closest to the knots of a, so that blending two closed shapes
+// does not twist them. Both paths must have the same number of knots.
+func alignCycle(a, b *mp.Path) *mp.Path {
+	ka := pathKnots(a)
+	best, bestCost := b, math.Inf(1)
+	for _, cand := range []*mp.Path{b, b.Reversed()} {
+		kb := pathKnots(cand)
+		for shift := range kb {
+			cost := 0.0
+			for i, k := range ka {
+				o := kb[(i+shift)%len(kb)]
+				dx, dy := k.XCoord-o.XCoord, k.YCoord-o.YCoord
+				cost += dx*dx + dy*dy
+			}
+			if cost < bestCost {
+				bestCost = cost
+				best = cand
+				best.Head = kb[shift]
+			}
+		}
+	}
+	return best
+}
+
+// interpolateStyle blends the numeric and color parts of two styles.
+// Non-numeric attributes (pen shape, dash, join, cap) switch over at t=0.5.
+func interpolateStyle(a, b mp.Style, t float64) mp.Style {
+	lerp := func(x, y float64) float64 { return x + t*(y-x) }
+	s := a
+	if t >= 0.5 {
+		s = b
+	}
+	s.Stroke = mixColors(a.Stroke, b.Stroke, t)
+	s.Fill = mixColors(a.Fill, b.Fill, t)
+	s.StrokeWidth = lerp(a.StrokeWidth, b.StrokeWidth)
+	if a.Arrow.Length != 0 && b.Arrow.Length != 0 {
+		s.Arrow.Length = lerp(a.Arrow.Length, b.Arrow.Length)
+		s.Arrow.Angle = lerp(a.Arrow.Angle, b.Arrow.Angle)
+	}
+	if a.Pen != nil && b.Pen != nil && a.Pen.Elliptical && b.Pen.Elliptical {
+		k := mp.CopyKnot(a.Pen.Head)
+		ka, kb := a.Pen.Head, b.Pen.Head
+		k.XCoord, k.YCoord = lerp(ka.XCoord, kb.XCoord), lerp(ka.YCoord, kb.YCoord)
+		k.LeftX, k.LeftY = lerp(ka.LeftX, kb.LeftX), lerp(ka.LeftY, kb.LeftY)
+		k.RightX, k.RightY = lerp(ka.RightX, kb.RightX), lerp(ka.RightY, kb.RightY)
+		k.Next, k.Prev = k, k
+		s.Pen = &mp.Pen{Head: k, Elliptical: true}
+	}
+	return s
+}
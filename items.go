@@ -0,0 +1,202 @@
+package hobby
+
+import (
+	"github.com/boxesandglue/mpgo/mp"
+	lua "github.com/speedata/go-lua"
+)
+
+// pictureEntry is one element of the flattened drawing list of a picture,
+// similar to MetaPost's object list: drawn objects plus begin/end markers
+// around groups, clipped and bounded parts.
+type pictureEntry struct {
+	kind   string
+	item   *pictureItem // drawn objects
+	styled *mp.Path     // paths with the styles they are drawn with
+	group  *picture     // group markers
+	depth  int
+}
+
+// entries returns the contents of p in drawing order. The styles of the
+// pictures and items are resolved as in the SVG output, with defaults
+// and the theme th.
+func (p *picture) entries(defaults *mp.Style, th *theme) []pictureEntry {
+	var list []pictureEntry
+	var visit func(pic *picture, defaults *mp.Style, depth int)
+	visit = func(pic *picture, defaults *mp.Style, depth int) {
+		defaults = inheritStyle(defaults, pic.style, th)
+		for _, it := range pic.items {
+			if it.path != nil {
+				styled := styledPath(it.path, inheritStyle(defaults, it.style, th))
+				list = append(list, pictureEntry{kind: pathKind(styled), item: it, styled: styled, depth: depth})
+				continue
+			}
+			if it.label != nil {
+				list = append(list, pictureEntry{kind: "label", item: it, depth: depth})
+				continue
+			}
+			g := it.group
+			kind := "group"
+			switch {
+			case g.clip != nil:
+				kind = "clip"
+			case g.bounds != nil:
+				kind = "bounds"
+			}
+			list = append(list, pictureEntry{kind: "begin" + kind, group: g, depth: depth})
+			visit(g, defaults, depth+1)
+			list = append(list, pictureEntry{kind: "end" + kind, group: g, depth: depth})
+		}
+	}
+	visit(p, defaults, 0)
+	return list
+}
+
+// pathKind returns "stroke", "fill" or "filldraw" depending on how p is
+// drawn.
+func pathKind(p *mp.Path) string {
+	filled := hasPaint(p.Style.Fill)
+	switch {
+	case filled && isStroked(p):
+		return "filldraw"
+	case filled:
+		return "fill"
+	}
+	return "stroke"
+}
+
+// pictureItems returns an iterator over the entries of pic:
+//
+//	for item in pic:items([{theme=t}]) do ... end
+//
+// Each item is a table with the fields type, depth and, depending on the
+// type, path and style, label, or picture, id, class, clippath and
+// boundspath for the begin/end markers. type and style are those the path
+// is drawn with, including the styles of the item, the pictures and the
+// theme. ownstyle is the style or role name set on the item or group
+// itself (pic:add(p, {style=}), pic:setstyle). Items and groups with an
+// opacity or blend mode also have the fields opacity and blend. Paths and
+// labels are the objects stored in the picture, so changing them changes
+// the picture.
+func pictureItems(l *lua.State, pic *picture, defaults *mp.Style, th *theme) int {
+	list := pic.entries(defaults, th)
+	i := 0
+	l.PushGoFunction(func(l *lua.State) int {
+		if i >= len(list) {
+			l.PushNil()
+			return 1
+		}
+		e := list[i]
+		i++
+		l.NewTable()
+		l.PushString(e.kind)
+		l.SetField(-2, "type")
+		l.PushInteger(e.depth)
+		l.SetField(-2, "depth")
+		if it := e.item; it != nil {
+			switch {
+			case it.path != nil:
+				pushPath(l, it.path)
+				l.SetField(-2, "path")
+				pushPathStyle(l, e.styled)
+				l.SetField(-2, "style")
+			case it.label != nil:
+				pushLabel(l, it.label)
+				l.SetField(-2, "label")
+			}
+			setStringField(l, "id", it.id)
+			setStringField(l, "class", it.class)
+			pushCompositing(l, it.compositing)
+			setStyleRefField(l, "ownstyle", it.style)
+			return 1
+		}
+		g := e.group
+		pushPicture(l, g)
+		l.SetField(-2, "picture")
+		setStyleRefField(l, "ownstyle", g.style)
+		setStringField(l, "id", g.id)
+		setStringField(l, "class", g.class)
+		pushCompositing(l, g.compositing)
+		if g.clip != nil {
+			pushPath(l, g.clip)
+			l.SetField(-2, "clippath")
+		}
+		if g.bounds != nil {
+			pushPath(l, g.bounds)
+			l.SetField(-2, "boundspath")
+		}
+		return 1
+	})
+	return 1
+}
+
+// setStringField sets field name of the table on top of the stack to s
+// unless s is empty.
+func setStringField(l *lua.State, name, s string) {
+	if s == "" {
+		return
+	}
+	l.PushString(s)
+	l.SetField(-2, name)
+}
+
+// setStyleRefField sets field name of the table on top of the stack to the
+// style or role name of r unless r is empty.
+func setStyleRefField(l *lua.State, name string, r styleRef) {
+	switch {
+	case r.style != nil:
+		pushStyle(l, *r.style)
+	case r.role != "":
+		l.PushString(r.role)
+	default:
+		return
+	}
+	l.SetField(-2, name)
+}
+
+// pushPathStyle pushes the style of p as a table with the fields stroke,
+// fill, strokeopacity, fillopacity, width, pen, dash, arrows, join and cap.
+// Unset colors, pen and dash are nil; the opacities, width, join and cap
+// are the values used for drawing.
+func pushPathStyle(l *lua.State, p *mp.Path) {
+	st := p.Style
+	l.NewTable()
+	if _, only := opacityOnly(st.Stroke); !only && st.Stroke.CSS() != "" {
+		pushColor(l, st.Stroke)
+		l.SetField(-2, "stroke")
+	}
+	if _, only := opacityOnly(st.Fill); !only && st.Fill.CSS() != "" {
+		pushColor(l, st.Fill)
+		l.SetField(-2, "fill")
+	}
+	l.PushNumber(paintOpacity(resolvePaint(st.Stroke, "black")))
+	l.SetField(-2, "strokeopacity")
+	l.PushNumber(paintOpacity(resolvePaint(st.Fill, "none")))
+	l.SetField(-2, "fillopacity")
+	l.PushNumber(strokeWidth(p))
+	l.SetField(-2, "width")
+	if st.Pen != nil {
+		pushPen(l, st.Pen)
+		l.SetField(-2, "pen")
+	}
+	if st.Dash != nil {
+		pushDash(l, st.Dash)
+		l.SetField(-2, "dash")
+	}
+
+	length, angle := arrowSize(p)
+	l.NewTable()
+	l.PushBoolean(st.Arrow.Start)
+	l.SetField(-2, "start")
+	l.PushBoolean(st.Arrow.End)
+	l.SetField(-2, "end")
+	l.PushNumber(length)
+	l.SetField(-2, "length")
+	l.PushNumber(angle)
+	l.SetField(-2, "angle")
+	l.SetField(-2, "arrows")
+
+	l.PushString(lineJoinName(st.LineJoin))
+	l.SetField(-2, "join")
+	l.PushString(lineCapName(st.LineCap))
+	l.SetField(-2, "cap")
+}
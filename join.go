@@ -0,0 +1,164 @@
+package hobby
+
+import (
+	"errors"
+	"math"
+
+	"github.com/boxesandglue/mpgo/draw"
+	"github.com/boxesandglue/mpgo/mp"
+	lua "github.com/speedata/go-lua"
+)
+
+// joinTolerance is the maximum distance between two endpoints that are
+// still considered coincident by join.
+const joinTolerance = 1e-4
+
+var (
+	errJoinCycle    = errors.New("cannot join or concatenate a cyclic path")
+	errJoinDistinct = errors.New("endpoints do not coincide (use concat)")
+)
+
+// checkPathOrPoint returns a path for the value at index. Points are
+// turned into a path consisting of a single knot.
+func checkPathOrPoint(l *lua.State, index int) *mp.Path {
+	if p, ok := l.ToUserData(index).(*mp.Path); ok {
+		return p
+	}
+	pt := checkPoint(l, index)
+	k := mp.NewKnot()
+	k.XCoord, k.YCoord = pt.X, pt.Y
+	k.LeftX, k.LeftY = pt.X, pt.Y
+	k.RightX, k.RightY = pt.X, pt.Y
+	k.LType = mp.KnotEndpoint
+	k.RType = mp.KnotEndpoint
+	p := mp.NewPath()
+	p.Append(k)
+	return p
+}
+
+// pathConcat implements the __concat metamethod: a .. b
+// Paths whose endpoints coincide are joined (MetaPost's &), otherwise
+// they are connected by a curve segment (MetaPost's ..).
+func pathConcat(l *lua.State) int {
+	a := checkPathOrPoint(l, 1)
+	b := checkPathOrPoint(l, 2)
+	var result *mp.Path
+	var err error
+	if endpointsCoincide(a, b) {
+		result, err = joinPaths(a, b)
+	} else {
+		result, err = concatPaths(a, b, false)
+	}
+	if err != nil {
+		lua.Errorf(l, "concat: %s", err.Error())
+		return 0
+	}
+	pushPath(l, result)
+	return 1
+}
+
+// lastKnot returns the final knot of an open path.
+func lastKnot(p *mp.Path) *mp.Knot {
+	return p.Head.Prev
+}
+
+// endpointsCoincide reports whether a ends where b starts.
+func endpointsCoincide(a, b *mp.Path) bool {
+	if a == nil || a.Head == nil || b == nil || b.Head == nil {
+		return false
+	}
+	end := lastKnot(a)
+	return math.Hypot(end.XCoord-b.Head.XCoord, end.YCoord-b.Head.YCoord) <= joinTolerance
+}
+
+// joinPaths mirrors MetaPost's "a & b": the last knot of a and the first knot
+// of b are merged into one. The endpoints must coincide.
+func joinPaths(a, b *mp.Path) (*mp.Path, error) {
+	if a == nil || a.Head == nil || b == nil || b.Head == nil {
+		return nil, errEmptyPath
+	}
+	if isCycle(a) || isCycle(b) {
+		return nil, errJoinCycle
+	}
+	if !endpointsCoincide(a, b) {
+		return nil, errJoinDistinct
+	}
+	result := a.Copy()
+	end := lastKnot(result)
+	first := b.Head
+	end.RightX, end.RightY = first.RightX, first.RightY
+	end.RType = first.RType
+	for _, k := range pathKnots(b)[1:] {
+		result.Append(mp.CopyKnot(k))
+	}
+	return result, nil
+}
+
+// concatPaths connects the end of a with the start of b by a new segment,
+// either a straight line (MetaPost's --) or a curve that continues the
+// directions of both paths (MetaPost's ..).
+func concatPaths(a, b *mp.Path, line bool) (*mp.Path, error) {
+	if a == nil || a.Head == nil || b == nil || b.Head == nil {
+		return nil, errEmptyPath
+	}
+	if isCycle(a) || isCycle(b) {
+		return nil, errJoinCycle
+	}
+	result := a.Copy()
+	end := lastKnot(result)
+	from := mp.P(end.XCoord, end.YCoord)
+	to := mp.P(b.Head.XCoord, b.Head.YCoord)
+
+	var c1, c2 mp.Point
+	if line {
+		c1 = mp.PointBetween(from, to, 1.0/3)
+		c2 = mp.PointBetween(from, to, 2.0/3)
+	} else {
+		chord := to.Sub(from)
+		outDir := endDirection(a, chord)
+		inDir := startDirection(b, chord)
+		seg, err := draw.NewPath().MoveTo(from).CurveToDir(to, outDir.Angle(), inDir.Angle()).Solve()
+		if err != nil {
+			return nil, err
+		}
+		c1 = mp.P(seg.Head.RightX, seg.Head.RightY)
+		c2 = mp.P(seg.Head.Next.LeftX, seg.Head.Next.LeftY)
+	}
+
+	end.RightX, end.RightY = c1.X, c1.Y
+	end.RType = mp.KnotExplicit
+	for i, k := range pathKnots(b) {
+		k = mp.CopyKnot(k)
+		if i == 0 {
+			k.LeftX, k.LeftY = c2.X, c2.Y
+			k.LType = mp.KnotExplicit
+		}
+		result.Append(k)
+	}
+	return result, nil
+}
+
+// endDirection returns the direction at the end of p, or fallback if the
+// path has no usable direction there (e.g. a single point).
+func endDirection(p *mp.Path, fallback mp.Point) mp.Point {
+	n := p.PathLength()
+	if n > 0 {
+		dx, dy := p.DirectionOf(mp.Number(n))
+		if math.Hypot(dx, dy) > joinTolerance {
+			return mp.P(dx, dy)
+		}
+	}
+	return fallback
+}
+
+// startDirection returns the direction at the start of p, or fallback if
+// the path has no usable direction there.
+func startDirection(p *mp.Path, fallback mp.Point) mp.Point {
+	if p.PathLength() > 0 {
+		dx, dy := p.DirectionOf(0)
+		if math.Hypot(dx, dy) > joinTolerance {
+			return mp.P(dx, dy)
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,176 @@
+package hobby
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/boxesandglue/mpgo/mp"
+	lua "github.com/speedata/go-lua"
+)
+
+// knotTypeNames maps knot types to the names used from Lua.
+var knotTypeNames = map[mp.KnotType]string{
+	mp.KnotEndpoint: "endpoint",
+	mp.KnotExplicit: "explicit",
+	mp.KnotGiven:    "given",
+	mp.KnotCurl:     "curl",
+	mp.KnotOpen:     "open",
+	mp.KnotEndCycle: "endcycle",
+}
+
+// parseKnotType converts a knot type name to mp.KnotType
+func parseKnotType(l *lua.State, index int) mp.KnotType {
+	name := lua.CheckString(l, index)
+	for t, n := range knotTypeNames {
+		if n == name {
+			return t
+		}
+	}
+	lua.Errorf(l, "unknown knot type: %s (use endpoint, explicit, given, curl, open, endcycle)", name)
+	return mp.KnotExplicit
+}
+
+// registerKnotMeta registers the metatable for knots
+func registerKnotMeta(l *lua.State) {
+	lua.NewMetaTable(l, "hobby.knot")
+	l.PushGoFunction(knotIndex)
+	l.SetField(-2, "__index")
+	l.PushGoFunction(knotNewIndex)
+	l.SetField(-2, "__newindex")
+	l.PushGoFunction(knotToString)
+	l.SetField(-2, "__tostring")
+	l.Pop(1)
+}
+
+// pushKnot pushes a knot as userdata. The knot is shared with its path,
+// so changes made through the userdata are visible in the path.
+func pushKnot(l *lua.State, k *mp.Knot) {
+	l.PushUserData(k)
+	lua.SetMetaTableNamed(l, "hobby.knot")
+}
+
+// checkKnot checks if value at index is a Knot
+func checkKnot(l *lua.State, index int) *mp.Knot {
+	ud := l.ToUserData(index)
+	if k, ok := ud.(*mp.Knot); ok {
+		return k
+	}
+	lua.Errorf(l, "expected knot at argument %d", index)
+	return nil
+}
+
+func knotIndex(l *lua.State) int {
+	k := checkKnot(l, 1)
+	key := lua.CheckString(l, 2)
+
+	switch key {
+	case "point":
+		pushPoint(l, mp.P(k.XCoord, k.YCoord))
+		return 1
+
+	case "precontrol":
+		pushPoint(l, mp.P(k.LeftX, k.LeftY))
+		return 1
+
+	case "postcontrol":
+		pushPoint(l, mp.P(k.RightX, k.RightY))
+		return 1
+
+	case "ltype":
+		l.PushString(knotTypeNames[k.LType])
+		return 1
+
+	case "rtype":
+		l.PushString(knotTypeNames[k.RType])
+		return 1
+	}
+
+	return 0
+}
+
+func knotNewIndex(l *lua.State) int {
+	k := checkKnot(l, 1)
+	key := lua.CheckString(l, 2)
+
+	switch key {
+	case "point":
+		p := checkPoint(l, 3)
+		k.XCoord, k.YCoord = p.X, p.Y
+	case "precontrol":
+		p := checkPoint(l, 3)
+		k.LeftX, k.LeftY = p.X, p.Y
+	case "postcontrol":
+		p := checkPoint(l, 3)
+		k.RightX, k.RightY = p.X, p.Y
+	case "ltype":
+		k.LType = parseKnotType(l, 3)
+	case "rtype":
+		k.RType = parseKnotType(l, 3)
+	default:
+		lua.Errorf(l, "cannot set knot field %s", key)
+	}
+	return 0
+}
+
+func knotToString(l *lua.State) int {
+	k := checkKnot(l, 1)
+	l.PushString(fmt.Sprintf("(%g, %g) controls (%g, %g) and (%g, %g)",
+		k.XCoord, k.YCoord, k.LeftX, k.LeftY, k.RightX, k.RightY))
+	return 1
+}
+
+// checkKnotIndex reads a 1-based knot index and returns the knot.
+func checkKnotIndex(l *lua.State, path *mp.Path, index int) *mp.Knot {
+	i := lua.CheckInteger(l, index)
+	knots := pathKnots(path)
+	if i < 1 || i > len(knots) {
+		lua.Errorf(l, "knot index %d out of range (1-%d)", i, len(knots))
+		return nil
+	}
+	return knots[i-1]
+}
+
+// insertKnotAt inserts a knot at time t without changing the shape of the
+// path. Returns the knot at time t (an existing one if t is an integer).
+func insertKnotAt(p *mp.Path, t float64) *mp.Knot {
+	n := p.PathLength()
+	knots := pathKnots(p)
+	if n == 0 {
+		return p.Head
+	}
+	if isCycle(p) {
+		t = math.Mod(t, float64(n))
+		if t < 0 {
+			t += float64(n)
+		}
+	} else {
+		t = math.Max(0, math.Min(t, float64(n)))
+	}
+	seg := int(math.Floor(t))
+	frac := t - float64(seg)
+	if frac < 1e-9 {
+		return knots[seg%len(knots)]
+	}
+	return splitKnot(knots[seg], frac)
+}
+
+// removeKnot unlinks a knot from its path. The segments on both sides are
+// replaced by a single segment using the outer control points.
+func removeKnot(p *mp.Path, k *mp.Knot) {
+	if k.Next == k {
+		p.Head = nil
+		return
+	}
+	cycle := isCycle(p)
+	prev, next := k.Prev, k.Next
+	prev.Next = next
+	next.Prev = prev
+	if k == p.Head {
+		p.Head = next
+	}
+	if !cycle {
+		// Keep the open ends marked as endpoints.
+		p.Head.LType = mp.KnotEndpoint
+		p.Head.Prev.RType = mp.KnotEndpoint
+	}
+}
@@ -0,0 +1,578 @@
+package hobby
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/boxesandglue/mpgo/mp"
+	lua "github.com/speedata/go-lua"
+)
+
+// Nonlinear constraints (distances, angles, points on paths) cannot be
+// used to eliminate an unknown like linear equations. They are collected
+// while the constraint calls are made and solved together by ctx:solve():
+// the unknowns that the linear equations leave independent are found with
+// the Levenberg-Marquardt method and then fixed by equations, so that all
+// variables depending on them become known as well. The start values are
+// the guesses of ctx:guess() and the previous solution, which picks one of
+// several solutions (e.g. of the two intersections of two circles).
+
+// nonlinearConstraint is the constraint residual(v) = 0, where v are the
+// values of exprs.
+type nonlinearConstraint struct {
+	call     equationCall
+	exprs    []*linExpr
+	residual func(v []float64) []float64
+}
+
+// Settings of the nonlinear solver.
+const (
+	nonlinearIterations = 200
+	nonlinearSamples    = 16 // samples per path segment for the nearest point
+)
+
+// constrainNonlinear makes the constraint call kind on the expressions
+// args. residual gets their values, x and y for pairs, in order.
+func (c *solverContext) constrainNonlinear(l *lua.State, kind string, args []*solverExpr, residual func(v []float64) []float64) {
+	err := c.constrain(l, kind, solverOp{run: func(*solverOp) error {
+		var exprs []*linExpr
+		for _, a := range args {
+			a, err := a.refresh()
+			if err != nil {
+				return err
+			}
+			exprs = append(exprs, a.x)
+			if a.pair {
+				exprs = append(exprs, a.y)
+			}
+		}
+		c.nonlinear = append(c.nonlinear, nonlinearConstraint{call: c.call, exprs: exprs, residual: residual})
+		return nil
+	}})
+	if err != nil {
+		lua.Errorf(l, "%s error: %s", kind, err.Error())
+	}
+}
+
+// settle solves the nonlinear constraints together with the inequalities
+// on their unknowns and fixes the unknowns they determine. An inequality
+// e >= 0 adds the residual min(e, 0), which vanishes where it holds.
+func (c *solverContext) settle(inequalities []inequality) error {
+	// the constraints in terms of the independent unknowns, which are the
+	// parameters of the search
+	param := map[int]int{}
+	var unknowns []int
+	reduced := make([][]*linExpr, len(c.nonlinear))
+	for k, nc := range c.nonlinear {
+		for _, e := range nc.exprs {
+			r := c.solver.reduce(e)
+			reduced[k] = append(reduced[k], r)
+			for _, i := range r.unknowns() {
+				if _, ok := param[i]; !ok {
+					param[i] = len(unknowns)
+					unknowns = append(unknowns, i)
+				}
+			}
+		}
+	}
+	bounds := make([]*linExpr, len(inequalities))
+	for k, ineq := range inequalities {
+		bounds[k] = c.solver.reduce(ineq.e)
+		for _, i := range bounds[k].unknowns() {
+			if _, ok := param[i]; !ok {
+				param[i] = len(unknowns)
+				unknowns = append(unknowns, i)
+			}
+		}
+	}
+	eval := func(e *linExpr, u []float64) float64 {
+		v := e.constant
+		for i, coef := range e.terms {
+			v += coef * u[param[i]]
+		}
+		return v
+	}
+	residual := func(k int, u []float64) []float64 {
+		v := make([]float64, len(reduced[k]))
+		for j, e := range reduced[k] {
+			v[j] = eval(e, u)
+		}
+		return c.nonlinear[k].residual(v)
+	}
+	constraints := func(u []float64) []float64 {
+		var r []float64
+		for k := range c.nonlinear {
+			r = append(r, residual(k, u)...)
+		}
+		return r
+	}
+	residuals := func(u []float64) []float64 {
+		r := constraints(u)
+		for _, e := range bounds {
+			r = append(r, math.Min(eval(e, u), 0))
+		}
+		return r
+	}
+
+	u := levenbergMarquardt(residuals, c.startValues(unknowns, param))
+
+	// Only the unknowns that the constraints determine near the solution
+	// are fixed; the others stay unknown, so that ctx:solve() reports
+	// them instead of keeping whatever values the search ended with.
+	fixed := determined(jacobian(constraints, u, constraints(u)))
+	c.call = equationCall{kind: "solve"}
+	for j, i := range unknowns {
+		if !fixed[j] {
+			continue
+		}
+		e := newLinExpr(-u[j])
+		e.addTerm(i, 1)
+		c.equate(e, "")
+	}
+	for _, p := range c.points {
+		for i := p.x; i <= p.x+1; i++ {
+			if v, ok := c.solver.value(i); ok {
+				c.guesses[i] = v
+			}
+		}
+	}
+	for _, n := range c.numerics {
+		if v, ok := c.solver.value(n.index); ok {
+			c.guesses[n.index] = v
+		}
+	}
+
+	for k, nc := range c.nonlinear {
+		for _, r := range residual(k, u) {
+			if math.Abs(r) > solverTolerance {
+				return fmt.Errorf("%s is not satisfied, off by %s", equationRecord{equationCall: nc.call}.describe(), formatNumber(r))
+			}
+		}
+	}
+	for k, e := range bounds {
+		if v := eval(e, u); v < -solverTolerance {
+			return fmt.Errorf("%s is violated by %s", inequalities[k].record.describe(), formatNumber(-v))
+		}
+	}
+	return nil
+}
+
+// startValues returns start values for the unknowns: the values that come
+// closest to the guesses for the variables, in the least squares sense.
+// Unknowns without a guess start near 0.
+func (c *solverContext) startValues(unknowns []int, param map[int]int) []float64 {
+	n := len(unknowns)
+	a := make([][]float64, n)
+	b := make([]float64, n)
+	for j := range a {
+		a[j] = make([]float64, n)
+		a[j][j] = 1e-6
+	}
+	guessed := make([]int, 0, len(c.guesses))
+	for i := range c.guesses {
+		guessed = append(guessed, i)
+	}
+	sort.Ints(guessed)
+	for _, i := range guessed {
+		g := c.guesses[i]
+		e := c.solver.reduce(&linExpr{terms: map[int]float64{i: 1}})
+		for j, cj := range e.terms {
+			pj, ok := param[j]
+			if !ok {
+				continue
+			}
+			for k, ck := range e.terms {
+				if pk, ok := param[k]; ok {
+					a[pj][pk] += cj * ck
+				}
+			}
+			b[pj] += cj * (g - e.constant)
+		}
+	}
+	u, ok := solveDense(a, b)
+	if !ok {
+		return make([]float64, n)
+	}
+	return u
+}
+
+// levenbergMarquardt minimizes the sum of squares of f(u), starting at u.
+func levenbergMarquardt(f func(u []float64) []float64, u []float64) []float64 {
+	sumSquares := func(r []float64) float64 {
+		var s float64
+		for _, v := range r {
+			s += v * v
+		}
+		return s
+	}
+	r := f(u)
+	cost := sumSquares(r)
+	lambda := 1e-3
+	for iter := 0; iter < nonlinearIterations && cost > 1e-24 && len(u) > 0; iter++ {
+		jac := jacobian(f, u, r)
+		// (J'J + lambda diag(J'J)) step = -J'r
+		a := make([][]float64, len(u))
+		g := make([]float64, len(u))
+		for j := range u {
+			a[j] = make([]float64, len(u))
+			for k := range u {
+				for i := range r {
+					a[j][k] += jac[j][i] * jac[k][i]
+				}
+			}
+			for i := range r {
+				g[j] -= jac[j][i] * r[i]
+			}
+			a[j][j] += lambda * math.Max(a[j][j], 1e-9)
+		}
+		step, ok := solveDense(a, g)
+		if !ok {
+			lambda *= 10
+			continue
+		}
+		trial := make([]float64, len(u))
+		var stepSize, size float64
+		for j := range u {
+			trial[j] = u[j] + step[j]
+			stepSize += step[j] * step[j]
+			size += u[j] * u[j]
+		}
+		rt := f(trial)
+		if ct := sumSquares(rt); ct < cost {
+			u, r, cost = trial, rt, ct
+			lambda = math.Max(lambda/10, 1e-12)
+			if math.Sqrt(stepSize) < 1e-12*(1+math.Sqrt(size)) {
+				break
+			}
+		} else {
+			lambda *= 10
+			if lambda > 1e12 {
+				break
+			}
+		}
+	}
+	return u
+}
+
+// jacobian returns the forward difference Jacobian of f at u, where
+// r = f(u), by columns: jac[j][i] is the derivative of r[i] by u[j].
+func jacobian(f func(u []float64) []float64, u, r []float64) [][]float64 {
+	jac := make([][]float64, len(u))
+	for j := range u {
+		h := 1e-7 * math.Max(1, math.Abs(u[j]))
+		old := u[j]
+		u[j] += h
+		rh := f(u)
+		u[j] = old
+		jac[j] = make([]float64, len(r))
+		for i := range r {
+			jac[j][i] = (rh[i] - r[i]) / h
+		}
+	}
+	return jac
+}
+
+// determined reports for each column of the Jacobian jac whether the
+// linearized residuals fix that unknown: the rows, reduced to echelon
+// form, must leave it independent of the unknowns they do not fix.
+func determined(jac [][]float64) []bool {
+	n := len(jac)
+	fixed := make([]bool, n)
+	if n == 0 {
+		return fixed
+	}
+	m := len(jac[0])
+	rows := make([][]float64, m)
+	var scale float64
+	for i := range rows {
+		rows[i] = make([]float64, n)
+		for j := range jac {
+			rows[i][j] = jac[j][i]
+			scale = math.Max(scale, math.Abs(jac[j][i]))
+		}
+	}
+	const eps = 1e-6
+	pivotRow := make([]int, n)
+	rank := 0
+	for col := range pivotRow {
+		pivotRow[col] = -1
+		if rank == m {
+			continue
+		}
+		pivot := rank
+		for row := rank + 1; row < m; row++ {
+			if math.Abs(rows[row][col]) > math.Abs(rows[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(rows[pivot][col]) <= eps*scale {
+			continue
+		}
+		rows[rank], rows[pivot] = rows[pivot], rows[rank]
+		p := rows[rank][col]
+		for k := range rows[rank] {
+			rows[rank][k] /= p
+		}
+		for row := range rows {
+			if row == rank {
+				continue
+			}
+			if f := rows[row][col]; f != 0 {
+				for k := range rows[row] {
+					rows[row][k] -= f * rows[rank][k]
+				}
+			}
+		}
+		pivotRow[col] = rank
+		rank++
+	}
+	for col, row := range pivotRow {
+		if row < 0 {
+			continue
+		}
+		fixed[col] = true
+		for free, r := range pivotRow {
+			if r < 0 && math.Abs(rows[row][free]) > eps {
+				fixed[col] = false
+			}
+		}
+	}
+	return fixed
+}
+
+// solveDense solves a x = b by Gaussian elimination with partial
+// pivoting; ok is false if a is singular. a and b are overwritten.
+func solveDense(a [][]float64, b []float64) (x []float64, ok bool) {
+	n := len(b)
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-300 {
+			return nil, false
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		b[col], b[pivot] = b[pivot], b[col]
+		for row := col + 1; row < n; row++ {
+			f := a[row][col] / a[col][col]
+			for k := col; k < n; k++ {
+				a[row][k] -= f * a[col][k]
+			}
+			b[row] -= f * b[col]
+		}
+	}
+	x = make([]float64, n)
+	for row := n - 1; row >= 0; row-- {
+		s := b[row]
+		for k := row + 1; k < n; k++ {
+			s -= a[row][k] * x[k]
+		}
+		x[row] = s / a[row][row]
+	}
+	return x, true
+}
+
+// nearestTime returns the time of the point of p that is closest to
+// (x, y).
+func nearestTime(p *mp.Path, x, y float64) float64 {
+	dist := func(t float64) float64 {
+		px, py := p.PointOf(mp.Number(t))
+		return (px-x)*(px-x) + (py-y)*(py-y)
+	}
+	n := p.PathLength()
+	best, bestDist := 0.0, math.Inf(1)
+	for i := 0; i <= n*nonlinearSamples; i++ {
+		t := float64(i) / nonlinearSamples
+		if d := dist(t); d < bestDist {
+			best, bestDist = t, d
+		}
+	}
+	// golden section search next to the best sample
+	lo := math.Max(best-1.0/nonlinearSamples, 0)
+	hi := math.Min(best+1.0/nonlinearSamples, float64(n))
+	const r = 0.6180339887498949
+	t1, t2 := hi-r*(hi-lo), lo+r*(hi-lo)
+	d1, d2 := dist(t1), dist(t2)
+	for hi-lo > 1e-12 {
+		if d1 < d2 {
+			hi, t2, d2 = t2, t1, d1
+			t1 = hi - r*(hi-lo)
+			d1 = dist(t1)
+		} else {
+			lo, t1, d1 = t1, t2, d2
+			t2 = lo + r*(hi-lo)
+			d2 = dist(t2)
+		}
+	}
+	return (lo + hi) / 2
+}
+
+// normalizedProduct returns the sine of the angle between (ux, uy) and
+// (wx, wy) if cross is set, otherwise the cosine; 1 if one is zero, so
+// that collapsed lines do not count as a solution.
+func normalizedProduct(ux, uy, wx, wy float64, cross bool) float64 {
+	l := math.Hypot(ux, uy) * math.Hypot(wx, wy)
+	if l < solverEps {
+		return 1
+	}
+	if cross {
+		return (ux*wy - uy*wx) / l
+	}
+	return (ux*wx + uy*wy) / l
+}
+
+// checkCtxExpr reads a variable, point, number or expression at index,
+// which must be a pair or a numeric and may only have unknowns of c.
+func checkCtxExpr(l *lua.State, c *solverContext, index int, pair bool) *solverExpr {
+	e := checkExpr(l, index)
+	if e.ctx != nil && e.ctx != c {
+		lua.Errorf(l, "argument %d belongs to another context", index)
+		return nil
+	}
+	if e.pair != pair {
+		kind := "numeric"
+		if pair {
+			kind = "pair"
+		}
+		lua.Errorf(l, "expected a %s at argument %d", kind, index)
+		return nil
+	}
+	return e
+}
+
+// nonlinearIndex returns the nonlinear constraint methods of contexts.
+func nonlinearIndex(l *lua.State, c *solverContext, key string) int {
+	switch key {
+	case "distance":
+		// ctx:distance(a, b, d) - constrain the distance of a and b to d
+		l.PushGoFunction(func(l *lua.State) int {
+			a := checkCtxExpr(l, c, 2, true)
+			b := checkCtxExpr(l, c, 3, true)
+			d := checkCtxExpr(l, c, 4, false)
+			c.constrainNonlinear(l, key, []*solverExpr{a, b, d}, func(v []float64) []float64 {
+				return []float64{math.Hypot(v[2]-v[0], v[3]-v[1]) - v[4]}
+			})
+			l.PushValue(1)
+			return 1
+		})
+		return 1
+
+	case "angle":
+		// ctx:angle(a, b, c, deg) - constrain the angle at b from b--a
+		// counterclockwise to b--c to deg degrees
+		l.PushGoFunction(func(l *lua.State) int {
+			a := checkCtxExpr(l, c, 2, true)
+			b := checkCtxExpr(l, c, 3, true)
+			cc := checkCtxExpr(l, c, 4, true)
+			deg := checkCtxExpr(l, c, 5, false)
+			c.constrainNonlinear(l, key, []*solverExpr{a, b, cc, deg}, func(v []float64) []float64 {
+				ux, uy := v[0]-v[2], v[1]-v[3]
+				wx, wy := v[4]-v[2], v[5]-v[3]
+				if math.Hypot(ux, uy)*math.Hypot(wx, wy) < solverEps {
+					// no angle at a collapsed leg
+					return []float64{1}
+				}
+				angle := math.Atan2(ux*wy-uy*wx, ux*wx+uy*wy)
+				return []float64{math.Remainder(angle-v[6]*math.Pi/180, 2*math.Pi)}
+			})
+			l.PushValue(1)
+			return 1
+		})
+		return 1
+
+	case "perpendicular", "parallel":
+		// ctx:perpendicular(a1, a2, b1, b2), ctx:parallel(a1, a2, b1, b2) -
+		// constrain the lines a1--a2 and b1--b2 to be perpendicular or
+		// parallel
+		l.PushGoFunction(func(l *lua.State) int {
+			args := make([]*solverExpr, 4)
+			for i := range args {
+				args[i] = checkCtxExpr(l, c, i+2, true)
+			}
+			cross := key == "parallel"
+			c.constrainNonlinear(l, key, args, func(v []float64) []float64 {
+				return []float64{normalizedProduct(v[2]-v[0], v[3]-v[1], v[6]-v[4], v[7]-v[5], cross)}
+			})
+			l.PushValue(1)
+			return 1
+		})
+		return 1
+
+	case "onpath":
+		// ctx:onpath(v, path) - constrain v to lie on the path
+		l.PushGoFunction(func(l *lua.State) int {
+			v := checkCtxExpr(l, c, 2, true)
+			path := checkPath(l, 3).Copy()
+			c.constrainNonlinear(l, key, []*solverExpr{v}, func(v []float64) []float64 {
+				x, y := path.PointOf(mp.Number(nearestTime(path, v[0], v[1])))
+				return []float64{v[0] - x, v[1] - y}
+			})
+			l.PushValue(1)
+			return 1
+		})
+		return 1
+
+	case "tangent":
+		// ctx:tangent(path, v, q) - constrain v to lie on the path and the
+		// line v--q to touch the path there
+		// ctx:tangent(path, q) - a new point variable where a line from q
+		// touches the path; ctx:guess() chooses between the tangents
+		l.PushGoFunction(func(l *lua.State) int {
+			path := checkPath(l, 2).Copy()
+			var touch *pointVar
+			var v, q *solverExpr
+			if l.IsNoneOrNil(4) {
+				q = checkCtxExpr(l, c, 3, true)
+				touch = c.newPoint("")
+				v = pointExpr(touch)
+			} else {
+				v = checkCtxExpr(l, c, 3, true)
+				q = checkCtxExpr(l, c, 4, true)
+			}
+			c.constrainNonlinear(l, key, []*solverExpr{v, q}, func(v []float64) []float64 {
+				t := mp.Number(nearestTime(path, v[0], v[1]))
+				x, y := path.PointOf(t)
+				dx, dy := path.DirectionOf(t)
+				return []float64{v[0] - x, v[1] - y, normalizedProduct(dx, dy, v[2]-v[0], v[3]-v[1], true)}
+			})
+			if touch != nil {
+				pushVar(l, touch)
+				return 1
+			}
+			l.PushValue(1)
+			return 1
+		})
+		return 1
+
+	case "guess":
+		// ctx:guess(v, x, y), ctx:guess(n, value) - start values for the
+		// nonlinear constraints, which choose between several solutions
+		l.PushGoFunction(func(l *lua.State) int {
+			switch v := l.ToUserData(2).(type) {
+			case *pointVar:
+				if v.ctx != c {
+					break
+				}
+				c.guesses[v.x] = lua.CheckNumber(l, 3)
+				c.guesses[v.x+1] = lua.CheckNumber(l, 4)
+				l.PushValue(1)
+				return 1
+			case *numericVar:
+				if v.ctx != c {
+					break
+				}
+				c.guesses[v.index] = lua.CheckNumber(l, 3)
+				l.PushValue(1)
+				return 1
+			}
+			lua.Errorf(l, "guess: expected a variable of this context at argument 2")
+			return 0
+		})
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,187 @@
+package hobby
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/boxesandglue/mpgo/mp"
+	lua "github.com/speedata/go-lua"
+)
+
+// opacityPrefix marks a paint that only sets an opacity, as produced by
+// fillopacity and strokeopacity before a color is known. Laid over a
+// color (or a color laid over it) it gives that color the opacity.
+const opacityPrefix = "hobby-opacity:"
+
+// opacityPaint returns a paint that only carries the opacity a.
+func opacityPaint(a float64) mp.Color {
+	return mp.ColorCSS(opacityPrefix + strconv.FormatFloat(a, 'g', -1, 64))
+}
+
+// opacityOnly returns the opacity of a paint created by opacityPaint. ok
+// is false for all other paints.
+func opacityOnly(c mp.Color) (a float64, ok bool) {
+	css := c.CSS()
+	if !strings.HasPrefix(css, opacityPrefix) {
+		return 0, false
+	}
+	a, err := strconv.ParseFloat(css[len(opacityPrefix):], 64)
+	return a, err == nil
+}
+
+// hasPaint reports whether c paints anything: it is set, not "none" and
+// not just an opacity.
+func hasPaint(c mp.Color) bool {
+	if _, ok := opacityOnly(c); ok {
+		return false
+	}
+	return c.CSS() != "" && c.CSS() != "none"
+}
+
+// withOpacity returns c with opacity a. The stop opacities of gradients
+// are multiplied by a. Unset paints become an opacity-only paint; patterns
+// and colors that cannot be decomposed are returned unchanged.
+func withOpacity(c mp.Color, a float64) mp.Color {
+	if c.CSS() == "" {
+		return opacityPaint(a)
+	}
+	if _, ok := opacityOnly(c); ok {
+		return opacityPaint(a)
+	}
+	if g, ok := gradientOf(c); ok {
+		for i := range g.Stops {
+			g.Stops[i].Opacity *= a
+		}
+		return g.color()
+	}
+	if m, ok := modelColorOf(c); ok {
+		m.Alpha = &a
+		return m.color()
+	}
+	r, g, b, _, ok := colorComponents(c)
+	if !ok {
+		return c
+	}
+	return mp.ColorRGBA(r, g, b, a)
+}
+
+// overlayPaint lays the paint s over dst. Opacity-only paints change the
+// opacity of the other paint, unless the color has an opacity of its own.
+func overlayPaint(dst, s mp.Color) mp.Color {
+	if a, ok := opacityOnly(s); ok {
+		return withOpacity(dst, a)
+	}
+	if s.CSS() == "" {
+		return dst
+	}
+	if a, ok := opacityOnly(dst); ok {
+		if _, has := colorOpacity(s); !has {
+			return withOpacity(s, a)
+		}
+	}
+	return s
+}
+
+// resolvePaint returns the paint used for drawing c: def if c is unset,
+// and def with the opacity if c only carries an opacity.
+func resolvePaint(c mp.Color, def string) mp.Color {
+	if a, ok := opacityOnly(c); ok {
+		return withOpacity(mp.ColorCSS(def), a)
+	}
+	if c.CSS() == "" {
+		return mp.ColorCSS(def)
+	}
+	return c
+}
+
+// checkOpacity reads an opacity between 0 and 1 at index.
+func checkOpacity(l *lua.State, index int) float64 {
+	a := lua.CheckNumber(l, index)
+	if a < 0 || a > 1 {
+		lua.Errorf(l, "opacity must be between 0 and 1, got %f", a)
+	}
+	return a
+}
+
+// blendModes are the blend modes of the SVG/CSS mix-blend-mode property,
+// which are also the PDF blend modes.
+var blendModes = []string{
+	"normal", "multiply", "screen", "overlay", "darken", "lighten",
+	"color-dodge", "color-burn", "hard-light", "soft-light", "difference",
+	"exclusion", "hue", "saturation", "color", "luminosity",
+}
+
+// checkBlendMode reads a blend mode name at index. "normal" is returned
+// as "".
+func checkBlendMode(l *lua.State, index int) string {
+	mode := lua.CheckString(l, index)
+	for _, m := range blendModes {
+		if m == mode {
+			if mode == "normal" {
+				return ""
+			}
+			return mode
+		}
+	}
+	lua.Errorf(l, "unknown blend mode: %s (use %s)", mode, strings.Join(blendModes, ", "))
+	return ""
+}
+
+// compositing describes how a group or an item is combined with what is
+// drawn below it. A group with an opacity is composited as a whole, so
+// overlapping parts of the group do not shine through each other. The
+// zero value draws normally.
+type compositing struct {
+	opacity    float64
+	hasOpacity bool
+	blend      string // mix-blend-mode, "" for normal
+}
+
+func (c compositing) isSet() bool {
+	return c.hasOpacity || c.blend != ""
+}
+
+// setOpacity sets the group opacity; 1 removes it.
+func (c *compositing) setOpacity(a float64) {
+	c.opacity, c.hasOpacity = a, a < 1
+}
+
+// attrs returns the SVG attributes for c.
+func (c compositing) attrs() string {
+	var attrs string
+	if c.hasOpacity {
+		attrs += fmt.Sprintf(` opacity="%.3f"`, c.opacity)
+	}
+	if c.blend != "" {
+		attrs += fmt.Sprintf(` style="mix-blend-mode:%s"`, c.blend)
+	}
+	return attrs
+}
+
+// checkCompositing reads the opacity and blend fields of the table at
+// index.
+func checkCompositing(l *lua.State, index int) compositing {
+	var c compositing
+	l.Field(index, "opacity")
+	if !l.IsNil(-1) {
+		c.setOpacity(checkOpacity(l, l.Top()))
+	}
+	l.Pop(1)
+	l.Field(index, "blend")
+	if !l.IsNil(-1) {
+		c.blend = checkBlendMode(l, l.Top())
+	}
+	l.Pop(1)
+	return c
+}
+
+// pushCompositing sets the opacity and blend fields of the table on top
+// of the stack if they are set in c.
+func pushCompositing(l *lua.State, c compositing) {
+	if c.hasOpacity {
+		l.PushNumber(c.opacity)
+		l.SetField(-2, "opacity")
+	}
+	setStringField(l, "blend", c.blend)
+}
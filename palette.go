@@ -0,0 +1,215 @@
+package hobby
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/boxesandglue/mpgo/mp"
+	lua "github.com/speedata/go-lua"
+)
+
+// sequentialPalettes are the perceptually uniform colormaps of
+// matplotlib, given as eleven evenly spaced samples (those of
+// viridisLite). Only these samples are exact: colors in between are
+// interpolated linearly in sRGB, so they approximate the colormaps and are
+// not exactly perceptually uniform. The error is small for figures, but
+// h.palette(name, 256) is not the 256 entry lookup table of matplotlib.
+var sequentialPalettes = map[string][]string{
+	"viridis": {"#440154", "#482576", "#414487", "#35608d", "#2a788e", "#21908c",
+		"#22a884", "#43bf71", "#7ad151", "#bbdf27", "#fde725"},
+	"magma": {"#000004", "#140e36", "#3b0f70", "#641a80", "#8c2981", "#b73779",
+		"#de4968", "#f7705c", "#fe9f6d", "#fecf92", "#fcfdbf"},
+	"inferno": {"#000004", "#160b39", "#420a68", "#6a176e", "#932667", "#bc3754",
+		"#dd513a", "#f37819", "#fca50a", "#f6d746", "#fcffa4"},
+	"plasma": {"#0d0887", "#3e049c", "#6300a7", "#8707a6", "#a62098", "#c03a83",
+		"#d5546e", "#e76f5a", "#f58c46", "#fdad32", "#f0f921"},
+}
+
+// qualitativePalettes are palettes for categories. "okabeito" is the
+// palette of Okabe and Ito, designed to be distinguishable with color
+// vision deficiencies.
+var qualitativePalettes = map[string][]string{
+	"okabeito": {"#e69f00", "#56b4e9", "#009e73", "#f0e442", "#0072b2", "#d55e00",
+		"#cc79a7", "#000000"},
+	"tableau10": {"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b",
+		"#e377c2", "#7f7f7f", "#bcbd22", "#17becf"},
+	"set2": {"#66c2a5", "#fc8d62", "#8da0cb", "#e78ac3", "#a6d854", "#ffd92f",
+		"#e5c494", "#b3b3b3"},
+	"dark2": {"#1b9e77", "#d95f02", "#7570b3", "#e7298a", "#66a61e", "#e6ab02",
+		"#a6761d", "#666666"},
+}
+
+// colormapAt returns the color of the sequential palette stops at t
+// (0-1).
+func colormapAt(stops []string, t float64) mp.Color {
+	t = clamp01(t)
+	pos := t * float64(len(stops)-1)
+	i := int(math.Min(math.Floor(pos), float64(len(stops)-2)))
+	return mixColors(mp.ColorCSS(stops[i]), mp.ColorCSS(stops[i+1]), pos-float64(i))
+}
+
+// paletteNames returns the names of all palettes, sorted.
+func paletteNames() string {
+	var names []string
+	for name := range sequentialPalettes {
+		names = append(names, name)
+	}
+	for name := range qualitativePalettes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// luaPalette returns n colors of a palette: hobby.palette(name[, n])
+// Sequential palettes are sampled evenly from end to end (n defaults
+// to 11); qualitative palettes return their first n colors (all by
+// default).
+func luaPalette(l *lua.State) int {
+	name := lua.CheckString(l, 1)
+	var colors []mp.Color
+	if stops, ok := sequentialPalettes[name]; ok {
+		n := lua.OptInteger(l, 2, len(stops))
+		if n < 1 {
+			lua.Errorf(l, "palette: n must be at least 1")
+			return 0
+		}
+		for i := 0; i < n; i++ {
+			t := 0.5
+			if n > 1 {
+				t = float64(i) / float64(n-1)
+			}
+			colors = append(colors, colormapAt(stops, t))
+		}
+	} else if list, ok := qualitativePalettes[name]; ok {
+		n := lua.OptInteger(l, 2, len(list))
+		if n < 1 || n > len(list) {
+			lua.Errorf(l, "palette: %s has %d colors", name, len(list))
+			return 0
+		}
+		for _, css := range list[:n] {
+			colors = append(colors, mp.ColorCSS(css))
+		}
+	} else {
+		lua.Errorf(l, "unknown palette: %s (use %s)", name, paletteNames())
+		return 0
+	}
+	l.CreateTable(len(colors), 0)
+	for i, c := range colors {
+		pushColor(l, c)
+		l.RawSetInt(-2, i+1)
+	}
+	return 1
+}
+
+// luaColormap returns a function that maps a number from 0 to 1 to a
+// color of a sequential palette: hobby.colormap(name)(t)
+// Between the samples of sequentialPalettes the colors are approximations.
+func luaColormap(l *lua.State) int {
+	name := lua.CheckString(l, 1)
+	stops, ok := sequentialPalettes[name]
+	if !ok {
+		lua.Errorf(l, "unknown colormap: %s (use viridis, magma, inferno, plasma)", name)
+		return 0
+	}
+	l.PushGoFunction(func(l *lua.State) int {
+		pushColor(l, colormapAt(stops, lua.CheckNumber(l, 1)))
+		return 1
+	})
+	return 1
+}
+
+// colorDeficiencies simulate the three kinds of dichromacy on linear RGB
+// (Machado, Oliveira and Fernandes 2009, severity 1).
+var colorDeficiencies = []struct {
+	name   string
+	matrix [3][3]float64
+}{
+	{"protanopia", [3][3]float64{
+		{0.152286, 1.052583, -0.204868},
+		{0.114503, 0.786281, 0.099216},
+		{-0.003882, -0.048116, 1.051998},
+	}},
+	{"deuteranopia", [3][3]float64{
+		{0.367322, 0.860646, -0.227968},
+		{0.280085, 0.672501, 0.047413},
+		{-0.011820, 0.042940, 0.968881},
+	}},
+	{"tritanopia", [3][3]float64{
+		{1.255528, -0.076749, -0.178779},
+		{-0.078411, 0.930809, 0.147602},
+		{0.004733, 0.691367, 0.303900},
+	}},
+}
+
+// simulateDeficiency returns the color r, g, b as seen with the
+// deficiency m.
+func simulateDeficiency(m [3][3]float64, r, g, b float64) (float64, float64, float64) {
+	lr, lg, lb := srgbToLinear(r), srgbToLinear(g), srgbToLinear(b)
+	return linearToSRGB(m[0][0]*lr + m[0][1]*lg + m[0][2]*lb),
+		linearToSRGB(m[1][0]*lr + m[1][1]*lg + m[1][2]*lb),
+		linearToSRGB(m[2][0]*lr + m[2][1]*lg + m[2][2]*lb)
+}
+
+// labDistance returns the CIE76 color difference of two RGB colors.
+func labDistance(r1, g1, b1, r2, g2, b2 float64) float64 {
+	l1, a1, bb1 := rgbToLab(r1, g1, b1)
+	l2, a2, bb2 := rgbToLab(r2, g2, b2)
+	return math.Sqrt((l1-l2)*(l1-l2) + (a1-a2)*(a1-a2) + (bb1-bb2)*(bb1-bb2))
+}
+
+// luaColorblindSafe checks that the colors of a list stay distinguishable
+// with protanopia, deuteranopia and tritanopia:
+//
+//	ok, worst = hobby.colorblindsafe(colors[, mindistance])
+//
+// Each pair of colors is simulated for each deficiency and compared by
+// its CIE L*a*b* distance, which must be at least mindistance (default
+// 10). worst describes the closest pair: {i, j, deficiency, distance}.
+func luaColorblindSafe(l *lua.State) int {
+	lua.CheckType(l, 1, lua.TypeTable)
+	minDistance := lua.OptNumber(l, 2, 10)
+	n := l.RawLength(1)
+	type rgb struct{ r, g, b float64 }
+	colors := make([]rgb, n)
+	for i := 1; i <= n; i++ {
+		l.RawGetInt(1, i)
+		r, g, b, _, ok := colorComponents(checkColor(l, l.Top()))
+		l.Pop(1)
+		if !ok {
+			lua.Errorf(l, "colorblindsafe: entry %d is not a plain color", i)
+			return 0
+		}
+		colors[i-1] = rgb{r, g, b}
+	}
+	worst, wi, wj, wname := math.Inf(1), 0, 0, ""
+	for _, d := range colorDeficiencies {
+		seen := make([]rgb, n)
+		for i, c := range colors {
+			seen[i].r, seen[i].g, seen[i].b = simulateDeficiency(d.matrix, c.r, c.g, c.b)
+		}
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n; j++ {
+				dist := labDistance(seen[i].r, seen[i].g, seen[i].b, seen[j].r, seen[j].g, seen[j].b)
+				if dist < worst {
+					worst, wi, wj, wname = dist, i+1, j+1, d.name
+				}
+			}
+		}
+	}
+	l.PushBoolean(worst >= minDistance)
+	if wi == 0 {
+		return 1
+	}
+	l.NewTable()
+	l.PushInteger(wi)
+	l.SetField(-2, "i")
+	l.PushInteger(wj)
+	l.SetField(-2, "j")
+	l.PushString(wname)
+	l.SetField(-2, "deficiency")
+	l.PushNumber(worst)
+	l.SetField(-2, "distance")
+	return 2
+}
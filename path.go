@@ -10,6 +10,7 @@ import (
 // PathBuilder wraps draw.PathBuilder for Lua
 type PathBuilder struct {
 	builder *draw.PathBuilder
+	style   styleSpec // applied to the path when it is built
 }
 
 // luaBuildCycle creates a closed region from multiple paths: hobby.buildcycle(p1, p2, ...)
@@ -94,6 +95,8 @@ func registerPathMeta(l *lua.State) {
 	lua.NewMetaTable(l, "hobby.path")
 	l.PushGoFunction(pathIndex)
 	l.SetField(-2, "__index")
+	l.PushGoFunction(pathConcat)
+	l.SetField(-2, "__concat")
 	l.Pop(1)
 }
 
@@ -237,129 +240,6 @@ func pathBuilderIndex(l *lua.State) int {
 		})
 		return 1
 
-	case "stroke":
-		l.PushGoFunction(func(l *lua.State) int {
-			c := checkColor(l, 2)
-			pb.builder.WithStrokeColor(c)
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "strokewidth":
-		l.PushGoFunction(func(l *lua.State) int {
-			w := lua.CheckNumber(l, 2)
-			pb.builder.WithStrokeWidth(w)
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "fill":
-		l.PushGoFunction(func(l *lua.State) int {
-			c := checkColor(l, 2)
-			pb.builder.WithFill(c)
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "pen":
-		l.PushGoFunction(func(l *lua.State) int {
-			pen := checkPen(l, 2)
-			pb.builder.WithPen(pen)
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "dash":
-		l.PushGoFunction(func(l *lua.State) int {
-			dash := checkDash(l, 2)
-			pb.builder.WithDashPattern(dash)
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "evenly":
-		l.PushGoFunction(func(l *lua.State) int {
-			pb.builder.DashedEvenly()
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "withdots":
-		l.PushGoFunction(func(l *lua.State) int {
-			pb.builder.DashedWithDots()
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "arrow":
-		l.PushGoFunction(func(l *lua.State) int {
-			pb.builder.WithArrow()
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "dblarrow":
-		l.PushGoFunction(func(l *lua.State) int {
-			pb.builder.WithDoubleArrow()
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "arrowstyle":
-		l.PushGoFunction(func(l *lua.State) int {
-			length := lua.CheckNumber(l, 2)
-			angle := lua.CheckNumber(l, 3)
-			pb.builder.WithArrowStyle(length, angle)
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "linejoin":
-		l.PushGoFunction(func(l *lua.State) int {
-			join := lua.CheckString(l, 2)
-			switch join {
-			case "miter":
-				pb.builder.WithLineJoin(mp.LineJoinMiter)
-			case "round":
-				pb.builder.WithLineJoin(mp.LineJoinRound)
-			case "bevel":
-				pb.builder.WithLineJoin(mp.LineJoinBevel)
-			default:
-				lua.Errorf(l, "unknown linejoin: %s (use miter, round, bevel)", join)
-			}
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "linecap":
-		l.PushGoFunction(func(l *lua.State) int {
-			cap := lua.CheckString(l, 2)
-			switch cap {
-			case "butt":
-				pb.builder.WithLineCap(mp.LineCapButt)
-			case "round":
-				pb.builder.WithLineCap(mp.LineCapRounded)
-			case "square":
-				pb.builder.WithLineCap(mp.LineCapSquared)
-			default:
-				lua.Errorf(l, "unknown linecap: %s (use butt, round, square)", cap)
-			}
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
 	case "close":
 		l.PushGoFunction(func(l *lua.State) int {
 			pb.builder.Close()
@@ -384,12 +264,23 @@ func pathBuilderIndex(l *lua.State) int {
 				lua.Errorf(l, "path build error: %s", err.Error())
 				return 0
 			}
+			buildStyled(path, pb.style)
 			pushPath(l, path)
 			return 1
 		})
 		return 1
 	}
 
+	if isStyleMethod(key) {
+		// pb:stroke(color), pb:strokewidth(w), ..., pb:withstyle(s)
+		l.PushGoFunction(func(l *lua.State) int {
+			pb.style = pb.style.with(checkStyleMethod(l, key))
+			l.PushValue(1)
+			return 1
+		})
+		return 1
+	}
+
 	return 0
 }
 
@@ -539,267 +430,133 @@ func pathIndex(l *lua.State) int {
 		})
 		return 1
 
-	case "scaled":
+	case "transformed":
 		l.PushGoFunction(func(l *lua.State) int {
-			s := lua.CheckNumber(l, 2)
-			scaled := path.Scaled(s)
-			pushPath(l, scaled)
+			pushPath(l, transformPath(path, checkTransform(l, 2)))
 			return 1
 		})
 		return 1
 
-	case "xscaled":
+	case "getstyle":
+		// path:getstyle() - the style as a table (stroke, fill, width, pen,
+		// dash, arrows, join, cap)
 		l.PushGoFunction(func(l *lua.State) int {
-			s := lua.CheckNumber(l, 2)
-			scaled := path.XScaled(mp.Number(s))
-			pushPath(l, scaled)
+			pushPathStyle(l, path)
 			return 1
 		})
 		return 1
 
-	case "yscaled":
+	case "join":
+		// path:join(other) - MetaPost's "path & other", endpoints must coincide
 		l.PushGoFunction(func(l *lua.State) int {
-			s := lua.CheckNumber(l, 2)
-			scaled := path.YScaled(mp.Number(s))
-			pushPath(l, scaled)
-			return 1
-		})
-		return 1
-
-	case "slanted":
-		l.PushGoFunction(func(l *lua.State) int {
-			s := lua.CheckNumber(l, 2)
-			slanted := path.Slanted(mp.Number(s))
-			pushPath(l, slanted)
-			return 1
-		})
-		return 1
-
-	case "shifted":
-		l.PushGoFunction(func(l *lua.State) int {
-			dx := lua.CheckNumber(l, 2)
-			dy := lua.CheckNumber(l, 3)
-			shifted := path.Shifted(dx, dy)
-			pushPath(l, shifted)
-			return 1
-		})
-		return 1
-
-	case "rotated":
-		l.PushGoFunction(func(l *lua.State) int {
-			angle := lua.CheckNumber(l, 2)
-			rotated := path.Rotated(angle)
-			pushPath(l, rotated)
-			return 1
-		})
-		return 1
-
-	case "zscaled":
-		l.PushGoFunction(func(l *lua.State) int {
-			// zscaled(a, b) or zscaled(point)
-			if l.IsUserData(2) {
-				p := checkPoint(l, 2)
-				scaled := path.ZScaled(mp.Number(p.X), mp.Number(p.Y))
-				pushPath(l, scaled)
-			} else {
-				a := lua.CheckNumber(l, 2)
-				b := lua.CheckNumber(l, 3)
-				scaled := path.ZScaled(mp.Number(a), mp.Number(b))
-				pushPath(l, scaled)
+			other := checkPathOrPoint(l, 2)
+			joined, err := joinPaths(path, other)
+			if err != nil {
+				lua.Errorf(l, "join: %s", err.Error())
+				return 0
 			}
+			pushPath(l, joined)
 			return 1
 		})
 		return 1
 
-	case "rotatedaround":
+	case "concat":
+		// path:concat(other[, {mode="line"|"curve"}]) - connect with -- or ..
 		l.PushGoFunction(func(l *lua.State) int {
-			// rotatedaround(point, angle) or rotatedaround(cx, cy, angle)
-			if l.IsUserData(2) {
-				p := checkPoint(l, 2)
-				angle := lua.CheckNumber(l, 3)
-				rotated := path.RotatedAround(mp.Number(p.X), mp.Number(p.Y), mp.Number(angle))
-				pushPath(l, rotated)
-			} else {
-				cx := lua.CheckNumber(l, 2)
-				cy := lua.CheckNumber(l, 3)
-				angle := lua.CheckNumber(l, 4)
-				rotated := path.RotatedAround(mp.Number(cx), mp.Number(cy), mp.Number(angle))
-				pushPath(l, rotated)
+			other := checkPathOrPoint(l, 2)
+			mode := "curve"
+			if l.IsTable(3) {
+				l.Field(3, "mode")
+				mode = lua.OptString(l, -1, "curve")
+				l.Pop(1)
 			}
-			return 1
-		})
-		return 1
-
-	case "scaledaround":
-		l.PushGoFunction(func(l *lua.State) int {
-			// scaledaround(point, scale) or scaledaround(cx, cy, scale)
-			if l.IsUserData(2) {
-				p := checkPoint(l, 2)
-				s := lua.CheckNumber(l, 3)
-				scaled := path.ScaledAround(mp.Number(p.X), mp.Number(p.Y), mp.Number(s))
-				pushPath(l, scaled)
-			} else {
-				cx := lua.CheckNumber(l, 2)
-				cy := lua.CheckNumber(l, 3)
-				s := lua.CheckNumber(l, 4)
-				scaled := path.ScaledAround(mp.Number(cx), mp.Number(cy), mp.Number(s))
-				pushPath(l, scaled)
+			if mode != "line" && mode != "curve" {
+				lua.Errorf(l, "unknown concat mode: %s (use line, curve)", mode)
+				return 0
 			}
-			return 1
-		})
-		return 1
-
-	case "reflectedabout":
-		l.PushGoFunction(func(l *lua.State) int {
-			// reflectedabout(p1, p2) or reflectedabout(x1, y1, x2, y2)
-			if l.IsUserData(2) {
-				p1 := checkPoint(l, 2)
-				p2 := checkPoint(l, 3)
-				reflected := path.ReflectedAbout(mp.Number(p1.X), mp.Number(p1.Y), mp.Number(p2.X), mp.Number(p2.Y))
-				pushPath(l, reflected)
-			} else {
-				x1 := lua.CheckNumber(l, 2)
-				y1 := lua.CheckNumber(l, 3)
-				x2 := lua.CheckNumber(l, 4)
-				y2 := lua.CheckNumber(l, 5)
-				reflected := path.ReflectedAbout(mp.Number(x1), mp.Number(y1), mp.Number(x2), mp.Number(y2))
-				pushPath(l, reflected)
+			result, err := concatPaths(path, other, mode == "line")
+			if err != nil {
+				lua.Errorf(l, "concat: %s", err.Error())
+				return 0
 			}
+			pushPath(l, result)
 			return 1
 		})
 		return 1
 
-	case "stroke":
-		l.PushGoFunction(func(l *lua.State) int {
-			c := checkColor(l, 2)
-			path.Style.Stroke = c
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "strokewidth":
-		l.PushGoFunction(func(l *lua.State) int {
-			w := lua.CheckNumber(l, 2)
-			path.Style.StrokeWidth = w
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "fill":
-		l.PushGoFunction(func(l *lua.State) int {
-			c := checkColor(l, 2)
-			path.Style.Fill = c
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "pen":
-		l.PushGoFunction(func(l *lua.State) int {
-			pen := checkPen(l, 2)
-			path.Style.Pen = pen
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "dash":
-		l.PushGoFunction(func(l *lua.State) int {
-			dash := checkDash(l, 2)
-			path.Style.Dash = dash
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "evenly":
-		l.PushGoFunction(func(l *lua.State) int {
-			path.Style.Dash = mp.DashEvenly()
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "withdots":
-		l.PushGoFunction(func(l *lua.State) int {
-			path.Style.Dash = mp.DashWithDots()
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "arrow":
+	case "knots":
+		// path:knots() - get all knots as a table (knot edits change the path)
 		l.PushGoFunction(func(l *lua.State) int {
-			path.Style.Arrow.End = true
-			if path.Style.Arrow.Length == 0 {
-				path.Style.Arrow.Length = mp.DefaultAHLength
-			}
-			if path.Style.Arrow.Angle == 0 {
-				path.Style.Arrow.Angle = mp.DefaultAHAngle
+			knots := pathKnots(path)
+			l.CreateTable(len(knots), 0)
+			for i, k := range knots {
+				pushKnot(l, k)
+				l.RawSetInt(-2, i+1)
 			}
-			l.PushValue(1)
 			return 1
 		})
 		return 1
 
-	case "dblarrow":
+	case "insertknot":
+		// path:insertknot(t) - insert a knot at time t without changing the shape
 		l.PushGoFunction(func(l *lua.State) int {
-			path.Style.Arrow.Start = true
-			path.Style.Arrow.End = true
-			if path.Style.Arrow.Length == 0 {
-				path.Style.Arrow.Length = mp.DefaultAHLength
-			}
-			if path.Style.Arrow.Angle == 0 {
-				path.Style.Arrow.Angle = mp.DefaultAHAngle
-			}
+			t := lua.CheckNumber(l, 2)
+			insertKnotAt(path, t)
 			l.PushValue(1)
 			return 1
 		})
 		return 1
 
-	case "arrowstyle":
+	case "removeknot":
+		// path:removeknot(i) - remove knot i (1-based, as in path:knots())
 		l.PushGoFunction(func(l *lua.State) int {
-			length := lua.CheckNumber(l, 2)
-			angle := lua.CheckNumber(l, 3)
-			path.Style.Arrow.Length = mp.Number(length)
-			path.Style.Arrow.Angle = mp.Number(angle)
+			k := checkKnotIndex(l, path, 2)
+			removeKnot(path, k)
 			l.PushValue(1)
 			return 1
 		})
 		return 1
 
-	case "linejoin":
+	case "setknot":
+		// path:setknot(i, point[, precontrol, postcontrol])
+		// path:setknot(i, {point=, precontrol=, postcontrol=, ltype=, rtype=})
 		l.PushGoFunction(func(l *lua.State) int {
-			join := lua.CheckString(l, 2)
-			switch join {
-			case "miter":
-				path.Style.LineJoin = mp.LineJoinMiter
-			case "round":
-				path.Style.LineJoin = mp.LineJoinRound
-			case "bevel":
-				path.Style.LineJoin = mp.LineJoinBevel
-			default:
-				lua.Errorf(l, "unknown linejoin: %s (use miter, round, bevel)", join)
+			k := checkKnotIndex(l, path, 2)
+			options := l.IsTable(3)
+			if options {
+				// a table with x and y is a point
+				l.Field(3, "x")
+				options = l.IsNil(-1)
+				l.Pop(1)
 			}
-			l.PushValue(1)
-			return 1
-		})
-		return 1
-
-	case "linecap":
-		l.PushGoFunction(func(l *lua.State) int {
-			cap := lua.CheckString(l, 2)
-			switch cap {
-			case "butt":
-				path.Style.LineCap = mp.LineCapButt
-			case "round":
-				path.Style.LineCap = mp.LineCapRounded
-			case "square":
-				path.Style.LineCap = mp.LineCapSquared
-			default:
-				lua.Errorf(l, "unknown linecap: %s (use butt, round, square)", cap)
+			if options {
+				found := false
+				for _, field := range []string{"point", "precontrol", "postcontrol", "ltype", "rtype"} {
+					pushKnot(l, k)
+					l.Field(3, field)
+					if l.IsNil(-1) {
+						l.Pop(2)
+						continue
+					}
+					found = true
+					l.SetField(-2, field)
+					l.Pop(1)
+				}
+				if !found {
+					lua.Errorf(l, "setknot: expected a point or a table with point, precontrol, postcontrol, ltype or rtype")
+					return 0
+				}
+			} else {
+				p := checkPoint(l, 3)
+				k.XCoord, k.YCoord = p.X, p.Y
+				if !l.IsNoneOrNil(4) {
+					c := checkPoint(l, 4)
+					k.LeftX, k.LeftY = c.X, c.Y
+				}
+				if !l.IsNoneOrNil(5) {
+					c := checkPoint(l, 5)
+					k.RightX, k.RightY = c.X, c.Y
+				}
 			}
 			l.PushValue(1)
 			return 1
@@ -838,6 +595,50 @@ func pathIndex(l *lua.State) int {
 			return 1
 		})
 		return 1
+
+	case "hatch":
+		// path:hatch{angle=, spacing=, offset=, stroke=, width=, pen=, ...} -
+		// a picture with the hatch lines of the region inside the path, cut
+		// at the outline
+		l.PushGoFunction(func(l *lua.State) int {
+			if !isCycle(path) {
+				lua.Errorf(l, "hatch needs a cyclic path")
+				return 0
+			}
+			angle, spacing, offset, s := checkHatch(l, 2)
+			pushPicture(l, hatchPicture(path, angle, spacing, offset, s))
+			return 1
+		})
+		return 1
+
+	case "truebbox":
+		// path:truebbox() - bounding box of the path as drawn, including
+		// pen, joins, caps and arrow heads
+		l.PushGoFunction(func(l *lua.State) int {
+			b := pathTrueBBox(path)
+			pushPath(l, bboxPath(b.minX, b.minY, b.maxX, b.maxY))
+			return 1
+		})
+		return 1
+	}
+
+	if isTransformOp(key) {
+		// path:scaled(s), path:shifted(dx, dy), path:rotated(angle), ...
+		l.PushGoFunction(func(l *lua.State) int {
+			pushPath(l, transformPath(path, checkTransformOp(l, key)))
+			return 1
+		})
+		return 1
+	}
+
+	if isStyleMethod(key) {
+		// path:stroke(color), path:strokewidth(w), ..., path:withstyle(s)
+		l.PushGoFunction(func(l *lua.State) int {
+			overlayStyle(&path.Style, checkStyleMethod(l, key))
+			l.PushValue(1)
+			return 1
+		})
+		return 1
 	}
 
 	return 0
@@ -876,3 +677,61 @@ func bboxPath(minX, minY, maxX, maxY float64) *mp.Path {
 	knots[0].Prev = knots[len(knots)-1]
 	return p
 }
+
+// pathKnots returns the knots of a path in order, starting at the head.
+func pathKnots(p *mp.Path) []*mp.Knot {
+	if p == nil || p.Head == nil {
+		return nil
+	}
+	var knots []*mp.Knot
+	cur := p.Head
+	for {
+		knots = append(knots, cur)
+		cur = cur.Next
+		if cur == nil || cur == p.Head {
+			break
+		}
+	}
+	return knots
+}
+
+// isCycle reports whether a path is closed (neither end is an endpoint knot).
+func isCycle(p *mp.Path) bool {
+	if p == nil || p.Head == nil || p.Head.Prev == nil {
+		return false
+	}
+	return p.Head.LType != mp.KnotEndpoint && p.Head.Prev.RType != mp.KnotEndpoint
+}
+
+// splitKnot inserts a new knot at fraction t of the segment starting at k,
+// using de Casteljau subdivision so the shape of the curve is unchanged.
+// Returns the new knot.
+func splitKnot(k *mp.Knot, t float64) *mp.Knot {
+	q := k.Next
+	u := 1 - t
+	lerp := func(a, b float64) float64 { return u*a + t*b }
+
+	// First level
+	ax, ay := lerp(k.XCoord, k.RightX), lerp(k.YCoord, k.RightY)
+	bx, by := lerp(k.RightX, q.LeftX), lerp(k.RightY, q.LeftY)
+	cx, cy := lerp(q.LeftX, q.XCoord), lerp(q.LeftY, q.YCoord)
+	// Second level
+	dx, dy := lerp(ax, bx), lerp(ay, by)
+	ex, ey := lerp(bx, cx), lerp(by, cy)
+
+	m := mp.NewKnot()
+	m.XCoord, m.YCoord = lerp(dx, ex), lerp(dy, ey)
+	m.LeftX, m.LeftY = dx, dy
+	m.RightX, m.RightY = ex, ey
+	m.LType = mp.KnotExplicit
+	m.RType = mp.KnotExplicit
+
+	k.RightX, k.RightY = ax, ay
+	q.LeftX, q.LeftY = cx, cy
+
+	m.Prev = k
+	m.Next = q
+	k.Next = m
+	q.Prev = m
+	return m
+}
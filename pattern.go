@@ -0,0 +1,122 @@
+package hobby
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/boxesandglue/mpgo/mp"
+	lua "github.com/speedata/go-lua"
+)
+
+// patternPrefix marks colors that describe a pattern fill. Like gradients,
+// patterns are stored in the CSS text of an mp.Color.
+const patternPrefix = "hobby-pattern:"
+
+// patternTiles holds the tile pictures of the patterns created in a Lua
+// state. A picture cannot be encoded in a color, so patterns refer to
+// their tile by its index here. The tiles live as long as the state.
+type patternTiles struct {
+	pictures []*picture
+}
+
+// patternTilesKey is the registry key of the pattern tiles of a state.
+const patternTilesKey = "hobby.patterntiles"
+
+// statePatternTiles returns the pattern tiles of the state l.
+func statePatternTiles(l *lua.State) *patternTiles {
+	l.Field(lua.RegistryIndex, patternTilesKey)
+	tiles, ok := l.ToUserData(-1).(*patternTiles)
+	l.Pop(1)
+	if !ok {
+		tiles = &patternTiles{}
+		l.PushUserData(tiles)
+		l.SetField(lua.RegistryIndex, patternTilesKey)
+	}
+	return tiles
+}
+
+// pattern is a fill that repeats a picture. The tile is the rectangle
+// (X, Y)-(X+Width, Y+Height) of the picture; Transform maps the tiling onto
+// the drawing when the painted path has been transformed.
+type pattern struct {
+	Tile          int
+	X, Y          float64
+	Width, Height float64
+	Transform     mp.Transform
+}
+
+// newPattern adds a copy of pic to tiles and returns the pattern.
+func (tiles *patternTiles) newPattern(pic *picture, x, y, width, height float64) *pattern {
+	tiles.pictures = append(tiles.pictures, pic.copy())
+	return &pattern{
+		Tile: len(tiles.pictures) - 1,
+		X:    x, Y: y,
+		Width: width, Height: height,
+		Transform: mp.Identity(),
+	}
+}
+
+// tile returns the picture repeated by pat, or an empty picture if pat was
+// made in another Lua state.
+func (tiles *patternTiles) tile(pat *pattern) *picture {
+	if tiles == nil || pat.Tile < 0 || pat.Tile >= len(tiles.pictures) {
+		return newPicture()
+	}
+	return tiles.pictures[pat.Tile]
+}
+
+// color returns the pattern encoded as a color.
+func (pat *pattern) color() mp.Color {
+	data, _ := json.Marshal(pat)
+	return mp.ColorCSS(patternPrefix + string(data))
+}
+
+// patternOf decodes the pattern stored in c. ok is false for all other
+// paints.
+func patternOf(c mp.Color) (*pattern, bool) {
+	css := c.CSS()
+	if !strings.HasPrefix(css, patternPrefix) {
+		return nil, false
+	}
+	pat := &pattern{}
+	if err := json.Unmarshal([]byte(css[len(patternPrefix):]), pat); err != nil {
+		return nil, false
+	}
+	return pat, true
+}
+
+// luaPattern creates a pattern fill: h.pattern(picture[, {width=, height=}])
+// Without width and height the tile is the bounding box of the picture
+// (setbounds can be used to choose it); otherwise the tile is the
+// rectangle from (0, 0) to (width, height). The picture is copied.
+func luaPattern(l *lua.State) int {
+	pic := checkPicture(l, 1)
+	b := picturePathBBox(pic, nil)
+	x, y, width, height := b.minX, b.minY, b.maxX-b.minX, b.maxY-b.minY
+	if l.IsTable(2) {
+		l.Field(2, "width")
+		l.Field(2, "height")
+		if !l.IsNil(-2) || !l.IsNil(-1) {
+			x, y = 0, 0
+			width = optNumberField(l, 2, "width", width)
+			height = optNumberField(l, 2, "height", height)
+		}
+		l.Pop(2)
+	}
+	if !b.valid && (width == 0 || height == 0) {
+		lua.Errorf(l, "pattern: the picture is empty")
+		return 0
+	}
+	if width <= 0 || height <= 0 {
+		lua.Errorf(l, "pattern: width and height must be positive")
+		return 0
+	}
+	pushColor(l, statePatternTiles(l).newPattern(pic, x, y, width, height).color())
+	return 1
+}
+
+// patternString describes a pattern for tostring().
+func patternString(pat *pattern) string {
+	return fmt.Sprintf("pattern(%g x %g)", pat.Width, pat.Height)
+}
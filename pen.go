@@ -48,6 +48,50 @@ func luaPenSpeck(l *lua.State) int {
 	return 1
 }
 
+// luaMakePen creates a polygonal pen from the convex hull of the knots
+// of a cyclic path, like MetaPost's makepen: hobby.makepen(path)
+// Control points are ignored.
+func luaMakePen(l *lua.State) int {
+	path := checkPath(l, 1)
+	if !isCycle(path) {
+		lua.Errorf(l, "makepen needs a cyclic path")
+		return 0
+	}
+	pushPen(l, mp.MakePen(path))
+	return 1
+}
+
+// luaMakePath returns the outline of a pen, like MetaPost's makepath:
+// hobby.makepath(pen)
+func luaMakePath(l *lua.State) int {
+	pushPath(l, penPath(checkPen(l, 1)))
+	return 1
+}
+
+// penPath returns the outline of pen as a cyclic path. Polygonal pens give
+// a polygon through their vertices, elliptical pens an eight-knot ellipse
+// like fullcircle transformed by the pen.
+func penPath(pen *mp.Pen) *mp.Path {
+	if pen == nil || pen.Head == nil {
+		return mp.NewPath()
+	}
+	if pen.Elliptical {
+		// the knot holds the center and the images of (1,0) and (0,1),
+		// see mp.PenCircle
+		k := pen.Head
+		return mp.FullCircle().Transformed(mp.Transform{
+			Tx: k.XCoord, Ty: k.YCoord,
+			Txx: k.LeftX - k.XCoord, Txy: k.RightX - k.XCoord,
+			Tyx: k.LeftY - k.YCoord, Tyy: k.RightY - k.YCoord,
+		})
+	}
+	var pts []mp.Point
+	for _, k := range pathKnots(&mp.Path{Head: pen.Head}) {
+		pts = append(pts, mp.P(k.XCoord, k.YCoord))
+	}
+	return polygonPath(pts)
+}
+
 // Pen wrapper and metatable
 
 type penWrapper struct {
@@ -85,6 +129,34 @@ func penIndex(l *lua.State) int {
 		pw := l.ToUserData(1).(*penWrapper)
 		l.PushBoolean(pw.pen.Elliptical)
 		return 1
+
+	case "makepath":
+		// pen:makepath() - the outline of the pen
+		pw := l.ToUserData(1).(*penWrapper)
+		l.PushGoFunction(func(l *lua.State) int {
+			pushPath(l, penPath(pw.pen))
+			return 1
+		})
+		return 1
+
+	case "transformed":
+		pw := l.ToUserData(1).(*penWrapper)
+		l.PushGoFunction(func(l *lua.State) int {
+			t := checkTransform(l, 2)
+			pushPen(l, transformPen(pw.pen, t))
+			return 1
+		})
+		return 1
+	}
+
+	if isTransformOp(key) {
+		// pen:scaled(s), pen:rotated(angle), ...
+		pw := l.ToUserData(1).(*penWrapper)
+		l.PushGoFunction(func(l *lua.State) int {
+			pushPen(l, transformPen(pw.pen, checkTransformOp(l, key)))
+			return 1
+		})
+		return 1
 	}
 
 	return 0
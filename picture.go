@@ -1,9 +1,6 @@
 package hobby
 
 import (
-	"math"
-
-	"github.com/boxesandglue/mpgo/draw"
 	"github.com/boxesandglue/mpgo/mp"
 	"github.com/boxesandglue/mpgo/svg"
 	lua "github.com/speedata/go-lua"
@@ -11,7 +8,7 @@ import (
 
 // luaNewPicture creates a new Picture: h.picture()
 func luaNewPicture(l *lua.State) int {
-	pic := draw.NewPicture()
+	pic := newPicture()
 	pushPicture(l, pic)
 	return 1
 }
@@ -25,15 +22,15 @@ func registerPictureMeta(l *lua.State) {
 }
 
 // pushPicture pushes a Picture as userdata
-func pushPicture(l *lua.State, p *draw.Picture) {
+func pushPicture(l *lua.State, p *picture) {
 	l.PushUserData(p)
 	lua.SetMetaTableNamed(l, "hobby.picture")
 }
 
 // checkPicture checks if value at index is a Picture
-func checkPicture(l *lua.State, index int) *draw.Picture {
+func checkPicture(l *lua.State, index int) *picture {
 	ud := l.ToUserData(index)
-	if p, ok := ud.(*draw.Picture); ok {
+	if p, ok := ud.(*picture); ok {
 		return p
 	}
 	lua.Errorf(l, "expected picture at argument %d", index)
@@ -72,39 +69,49 @@ func pictureIndex(l *lua.State) int {
 
 	switch key {
 	case "add":
-		// pic:add(path) - add a path to the picture
+		// pic:add(path[, {id=, class=, style=, opacity=, blend=}]) - add a
+		// path to the picture; style is a style or a role name of the theme
 		l.PushGoFunction(func(l *lua.State) int {
 			path := checkPath(l, 2)
-			pic.AddPath(path)
+			it := pic.addPath(path)
+			if l.IsTable(3) {
+				it.id, it.class = optStringField(l, 3, "id"), optStringField(l, 3, "class")
+				it.compositing = checkCompositing(l, 3)
+				l.Field(3, "style")
+				if !l.IsNil(-1) {
+					it.style = checkStyleRef(l, l.Top())
+				}
+				l.Pop(1)
+			}
 			l.PushValue(1) // return self for chaining
 			return 1
 		})
 		return 1
 
 	case "addpicture":
-		// pic:addpicture(other) - add all paths from another picture
+		// pic:addpicture(other) - add the contents of another picture
 		l.PushGoFunction(func(l *lua.State) int {
 			other := checkPicture(l, 2)
-			pic.AddPicture(other)
+			pic.addPicture(other)
 			l.PushValue(1) // return self for chaining
 			return 1
 		})
 		return 1
 
 	case "clip":
-		// pic:clip(clipPath) - set clipping path
+		// pic:clip(clipPath) - clip everything drawn so far; clips nest
 		l.PushGoFunction(func(l *lua.State) int {
 			clipPath := checkPath(l, 2)
-			pic.Clip(clipPath)
+			pic.clipTo(clipPath)
 			l.PushValue(1) // return self for chaining
 			return 1
 		})
 		return 1
 
 	case "clippath":
-		// pic:clippath() - get current clipping path
+		// pic:clippath() - get the clipping path applied last
 		l.PushGoFunction(func(l *lua.State) int {
-			cp := pic.ClipPath()
+			cp := pic.clipPath()
 			if cp == nil {
 				l.PushNil()
 			} else {
@@ -114,10 +121,54 @@ func pictureIndex(l *lua.State) int {
 		})
 		return 1
 
+	case "setstyle":
+		// pic:setstyle(style | role) - default style for the paths of the
+		// picture, applied when the SVG is written; nil removes it
+		l.PushGoFunction(func(l *lua.State) int {
+			if l.IsNoneOrNil(2) {
+				pic.style = styleRef{}
+			} else {
+				pic.style = checkStyleRef(l, 2)
+			}
+			l.PushValue(1) // return self for chaining
+			return 1
+		})
+		return 1
+
+	case "setopacity":
+		// pic:setopacity(a) - draw the picture as a transparency group:
+		// the picture is composited as a whole with opacity a
+		l.PushGoFunction(func(l *lua.State) int {
+			pic.setOpacity(checkOpacity(l, 2))
+			l.PushValue(1) // return self for chaining
+			return 1
+		})
+		return 1
+
+	case "setblend":
+		// pic:setblend(mode) - blend the picture with what is below it
+		// (multiply, screen, overlay, darken, lighten, ...)
+		l.PushGoFunction(func(l *lua.State) int {
+			pic.blend = checkBlendMode(l, 2)
+			l.PushValue(1) // return self for chaining
+			return 1
+		})
+		return 1
+
+	case "setbounds":
+		// pic:setbounds(path) - use the bounding box of path as the bounds of
+		// everything drawn so far
+		l.PushGoFunction(func(l *lua.State) int {
+			pic.setBounds(checkPath(l, 2))
+			l.PushValue(1) // return self for chaining
+			return 1
+		})
+		return 1
+
 	case "paths":
 		// pic:paths() - get all paths as a table
 		l.PushGoFunction(func(l *lua.State) int {
-			paths := pic.Paths()
+			paths := pic.paths()
 			l.CreateTable(len(paths), 0)
 			for i, p := range paths {
 				pushPath(l, p)
@@ -134,10 +185,11 @@ func pictureIndex(l *lua.State) int {
 			pos := checkPoint(l, 3)
 			anchorStr := lua.OptString(l, 4, "center")
 			anchor := parseAnchor(anchorStr)
-			label := pic.LabelWithStyle(text, pos, anchor)
+			label := mp.NewLabel(text, pos, anchor)
 			if l.Top() >= 5 && l.IsTable(5) {
 				applyLabelOptions(l, 5, label)
 			}
+			pic.addLabel(label)
 			l.PushValue(1) // return self for chaining
 			return 1
 		})
@@ -150,7 +202,7 @@ func pictureIndex(l *lua.State) int {
 			pos := checkPoint(l, 3)
 			anchorStr := lua.OptString(l, 4, "center")
 			anchor := parseAnchor(anchorStr)
-			label := pic.LabelWithStyle(text, pos, anchor)
+			label := mp.NewLabel(text, pos, anchor)
 			color := mp.ColorCSS("black")
 			if l.Top() >= 5 && !l.IsNil(5) {
 				if l.IsTable(5) {
@@ -167,16 +219,26 @@ func pictureIndex(l *lua.State) int {
 			dot = mp.Shifted(pos.X, pos.Y).ApplyToPath(dot)
 			dot.Style.Fill = color
 			dot.Style.Stroke = mp.ColorCSS("none")
-			pic.AddPath(dot)
+			pic.addPath(dot)
+			pic.addLabel(label)
 			l.PushValue(1) // return self for chaining
 			return 1
 		})
 		return 1
 
+	case "items":
+		// pic:items([{theme=t}]) - iterate over all drawn objects and group
+		// markers; the styles are resolved with the theme as in svg:theme(t)
+		l.PushGoFunction(func(l *lua.State) int {
+			th := optTheme(l, 2)
+			return pictureItems(l, pic, inheritStyle(nil, styleRef{role: "default"}, th), th)
+		})
+		return 1
+
 	case "labels":
 		// pic:labels() - get all labels as a table
 		l.PushGoFunction(func(l *lua.State) int {
-			labels := pic.Labels()
+			labels := pic.labels()
 			l.CreateTable(len(labels), 0)
 			for i, lbl := range labels {
 				pushLabel(l, lbl)
@@ -190,7 +252,7 @@ func pictureIndex(l *lua.State) int {
 		// pic:converttopaths(face) - convert all labels to glyph outline paths
 		l.PushGoFunction(func(l *lua.State) int {
 			face := checkFace(l, 2)
-			if err := pic.ConvertLabelsToPathsWithFont(face); err != nil {
+			if err := pic.convertLabels(face); err != nil {
 				lua.Errorf(l, "converttopaths: %s", err)
 				return 0
 			}
@@ -231,44 +293,187 @@ func pictureIndex(l *lua.State) int {
 			return 1
 		})
 		return 1
+
+	case "truebbox":
+		// pic:truebbox([{glyphs=face, theme=t}]) - bounding box of the drawn
+		// ink: pens, joins, caps, arrow heads and labels (measured with the
+		// font if given), with the styles of the picture and the theme as in
+		// svg:theme(t). Ignores setbounds.
+		l.PushGoFunction(func(l *lua.State) int {
+			th := optTheme(l, 2)
+			defaults := inheritStyle(nil, styleRef{role: "default"}, th)
+			b, err := pictureTrueBBox(pic, optGlyphs(l, 2), defaults, th)
+			if err != nil {
+				lua.Errorf(l, "truebbox: %s", err.Error())
+				return 0
+			}
+			pushPath(l, bboxPath(b.minX, b.minY, b.maxX, b.maxY))
+			return 1
+		})
+		return 1
+
+	case "group":
+		// pic:group(name[, class]) - new nested group on top of the picture
+		l.PushGoFunction(func(l *lua.State) int {
+			name := lua.CheckString(l, 2)
+			class := lua.OptString(l, 3, "")
+			pushPicture(l, pic.group(name, class))
+			return 1
+		})
+		return 1
+
+	case "layer":
+		// pic:layer(name) - named layer, created on top of the picture on first use
+		l.PushGoFunction(func(l *lua.State) int {
+			name := lua.CheckString(l, 2)
+			pushPicture(l, pic.layer(name))
+			return 1
+		})
+		return 1
+
+	case "raise", "lower":
+		// pic:raise(item[, ref]) - move a path, label or group to the top (or above ref)
+		// pic:lower(item[, ref]) - move it to the bottom (or below ref)
+		l.PushGoFunction(func(l *lua.State) int {
+			obj := checkPictureObject(l, 2)
+			var ref any
+			if !l.IsNoneOrNil(3) {
+				ref = checkPictureObject(l, 3)
+			}
+			if !pic.restack(obj, ref, key == "raise") {
+				lua.Errorf(l, "%s: item is not part of the picture", key)
+				return 0
+			}
+			l.PushValue(1) // return self for chaining
+			return 1
+		})
+		return 1
+
+	case "id":
+		l.PushString(pic.id)
+		return 1
+
+	case "class":
+		l.PushString(pic.class)
+		return 1
+
+	case "setid":
+		l.PushGoFunction(func(l *lua.State) int {
+			pic.id = lua.CheckString(l, 2)
+			l.PushValue(1)
+			return 1
+		})
+		return 1
+
+	case "setclass":
+		l.PushGoFunction(func(l *lua.State) int {
+			pic.class = lua.CheckString(l, 2)
+			l.PushValue(1)
+			return 1
+		})
+		return 1
+
+	case "transformed":
+		// pic:transformed(t[, {glyphs=face}]) - transformed copy; with a font
+		// face the labels are converted to outlines and transformed as well
+		l.PushGoFunction(func(l *lua.State) int {
+			t := checkTransform(l, 2)
+			result, err := transformPicture(pic, t, optGlyphs(l, 3))
+			if err != nil {
+				lua.Errorf(l, "transformed: %s", err.Error())
+				return 0
+			}
+			pushPicture(l, result)
+			return 1
+		})
+		return 1
+	}
+
+	if isTransformOp(key) {
+		// pic:scaled(s), pic:shifted(dx, dy), pic:rotated(angle), ...
+		l.PushGoFunction(func(l *lua.State) int {
+			result, _ := transformPicture(pic, checkTransformOp(l, key), nil)
+			pushPicture(l, result)
+			return 1
+		})
+		return 1
 	}
 
 	return 0
 }
 
 // pictureBBox computes the bounding box of a picture.
-// If the picture has a clip path, the clip path bounds are used (like MetaPost).
-// Otherwise, bounds of all paths are aggregated with stroke width padding.
-func pictureBBox(pic *draw.Picture) (minX, minY, maxX, maxY float64) {
-	if clip := pic.ClipPath(); clip != nil {
-		return svg.PathBBox(clip)
+// Bounds of all paths are aggregated with stroke width padding. Clipped
+// groups contribute the part of their content inside the clip path's
+// bounding box, and bounds set with setbounds replace the bounding box of
+// the content (like MetaPost).
+func pictureBBox(pic *picture) (minX, minY, maxX, maxY float64) {
+	b := picturePathBBox(pic, nil)
+	if !b.valid {
+		return 0, 0, 0, 0
 	}
-	minX, minY = math.Inf(1), math.Inf(1)
-	maxX, maxY = math.Inf(-1), math.Inf(-1)
-	for _, p := range pic.Paths() {
-		pMinX, pMinY, pMaxX, pMaxY := svg.PathBBox(p)
-		halfStroke := p.Style.StrokeWidth / 2
-		pMinX -= halfStroke
-		pMinY -= halfStroke
-		pMaxX += halfStroke
-		pMaxY += halfStroke
-		if pMinX < minX {
-			minX = pMinX
-		}
-		if pMinY < minY {
-			minY = pMinY
-		}
-		if pMaxX > maxX {
-			maxX = pMaxX
-		}
-		if pMaxY > maxY {
-			maxY = pMaxY
+	return b.minX, b.minY, b.maxX, b.maxY
+}
+
+// picturePathBBox is pictureBBox for pic with the style defaults of the
+// enclosing pictures. Stroke widths are taken from the styles of the
+// pictures and items as well as from the paths.
+func picturePathBBox(pic *picture, defaults *mp.Style) bbox {
+	if pic.bounds != nil {
+		return pathBBox(pic.bounds)
+	}
+	defaults = inheritStyle(defaults, pic.style, nil)
+	var b bbox
+	for _, it := range pic.items {
+		switch {
+		case it.path != nil && it.path.Head != nil:
+			p := styledPath(it.path, inheritStyle(defaults, it.style, nil))
+			pMinX, pMinY, pMaxX, pMaxY := svg.PathBBox(p)
+			halfStroke := p.Style.StrokeWidth / 2
+			b.add(pMinX-halfStroke, pMinY-halfStroke, pMaxX+halfStroke, pMaxY+halfStroke)
+		case it.group != nil:
+			b.addBox(picturePathBBox(it.group, defaults))
 		}
 	}
-	if math.IsInf(minX, 1) {
-		return 0, 0, 0, 0
+	return b.clipped(pic.clip)
+}
+
+// optGlyphs returns the font face of the option glyphs in the table at
+// index, or nil.
+func optGlyphs(l *lua.State, index int) mp.FontRenderer {
+	if !l.IsTable(index) {
+		return nil
 	}
-	return
+	l.Field(index, "glyphs")
+	defer l.Pop(1)
+	if l.IsNil(-1) {
+		return nil
+	}
+	return checkFace(l, -1)
+}
+
+// optTheme returns the theme of the option theme in the table at index,
+// or nil.
+func optTheme(l *lua.State, index int) *theme {
+	if !l.IsTable(index) {
+		return nil
+	}
+	l.Field(index, "theme")
+	defer l.Pop(1)
+	if l.IsNil(-1) {
+		return nil
+	}
+	return checkTheme(l, -1)
+}
+
+// checkPictureObject returns the path, label or picture at index.
+func checkPictureObject(l *lua.State, index int) any {
+	switch v := l.ToUserData(index).(type) {
+	case *mp.Path, *mp.Label, *picture:
+		return v
+	}
+	lua.Errorf(l, "expected path, label or picture at argument %d", index)
+	return nil
 }
 
 // applyLabelOptions reads label options from a Lua table at the given stack index.
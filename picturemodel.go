@@ -0,0 +1,316 @@
+package hobby
+
+import (
+	"math"
+
+	"github.com/boxesandglue/mpgo/mp"
+)
+
+// picture is an ordered list of items that are drawn from bottom to top.
+// Unlike draw.Picture it keeps paths and labels in drawing order and can
+// hold nested groups, which become <g> elements in the SVG output.
+type picture struct {
+	id     string // id attribute of the group in the SVG output
+	class  string // class attribute of the group in the SVG output
+	items  []*pictureItem
+	clip   *mp.Path
+	bounds *mp.Path // set by setbounds: replaces the bounding box of the contents
+	style  styleRef // default style of the paths in the picture
+	layers map[string]*picture
+	compositing
+}
+
+// pictureItem is one entry of a picture: a path, a label or a group.
+// Exactly one of the fields path, label and group is set.
+type pictureItem struct {
+	path  *mp.Path
+	label *mp.Label
+	group *picture
+	id    string
+	class string
+	style styleRef
+	compositing
+}
+
+// object returns the value stored in the item.
+func (it *pictureItem) object() any {
+	switch {
+	case it.path != nil:
+		return it.path
+	case it.label != nil:
+		return it.label
+	}
+	return it.group
+}
+
+func newPicture() *picture {
+	return &picture{}
+}
+
+func (p *picture) addPath(path *mp.Path) *pictureItem {
+	it := &pictureItem{path: path}
+	p.items = append(p.items, it)
+	return it
+}
+
+func (p *picture) addLabel(label *mp.Label) *pictureItem {
+	it := &pictureItem{label: label}
+	p.items = append(p.items, it)
+	return it
+}
+
+// group appends a new empty group and returns it.
+func (p *picture) group(id, class string) *picture {
+	g := &picture{id: id, class: class}
+	p.items = append(p.items, &pictureItem{group: g})
+	return g
+}
+
+// layer returns the layer with the given name, creating it on top of the
+// picture if it does not exist yet.
+func (p *picture) layer(name string) *picture {
+	if g, ok := p.layers[name]; ok {
+		return g
+	}
+	if p.layers == nil {
+		p.layers = make(map[string]*picture)
+	}
+	g := p.group(name, "layer")
+	p.layers[name] = g
+	return g
+}
+
+// clipTo clips everything drawn so far to path, like MetaPost's
+// "clip pic to path": the current items move into a clipped group and
+// content added later stays unclipped. Clipping again wraps the group
+// once more, so successive clip regions intersect. The layers move into
+// the clipped group as well; pic:layer(name) then starts a new layer.
+func (p *picture) clipTo(path *mp.Path) {
+	g := &picture{items: p.items, clip: path}
+	p.items = []*pictureItem{{group: g}}
+	p.layers = nil
+}
+
+// setBounds makes the bounding box of path the bounding box of
+// everything drawn so far, like MetaPost's "setbounds pic to path". The
+// contents are drawn unchanged. As with clipTo the layers end up inside
+// the group.
+func (p *picture) setBounds(path *mp.Path) {
+	g := &picture{items: p.items, bounds: path}
+	p.items = []*pictureItem{{group: g}}
+	p.layers = nil
+}
+
+// clipPath returns the clip path applied last to the picture, or nil.
+func (p *picture) clipPath() *mp.Path {
+	if p.clip != nil {
+		return p.clip
+	}
+	for i := len(p.items) - 1; i >= 0; i-- {
+		if g := p.items[i].group; g != nil && g.clip != nil && g.id == "" && g.class == "" {
+			return g.clip
+		}
+	}
+	return nil
+}
+
+// addPicture adds the contents of other on top of p. A picture with a
+// clipping path, bounds, style, opacity, blend mode or an id is added as a
+// group so that these survive.
+func (p *picture) addPicture(other *picture) {
+	if other.clip != nil || other.bounds != nil || other.style != (styleRef{}) || other.isSet() || other.id != "" || other.class != "" {
+		p.items = append(p.items, &pictureItem{group: other.copy()})
+		return
+	}
+	p.items = append(p.items, other.copy().items...)
+}
+
+// copy returns a copy of the item lists of p and its groups. Paths and
+// labels are shared.
+func (p *picture) copy() *picture {
+	return p.copyGroups(make(map[*picture]*picture))
+}
+
+// copyGroups is copy, recording the copy of every group in groups so that
+// layers point to the copies of their groups wherever these are nested.
+func (p *picture) copyGroups(groups map[*picture]*picture) *picture {
+	result := &picture{id: p.id, class: p.class, clip: p.clip, bounds: p.bounds, style: p.style, compositing: p.compositing}
+	groups[p] = result
+	for _, it := range p.items {
+		cp := *it
+		if it.group != nil {
+			cp.group = it.group.copyGroups(groups)
+		}
+		result.items = append(result.items, &cp)
+	}
+	for name, g := range p.layers {
+		if cp, ok := groups[g]; ok {
+			if result.layers == nil {
+				result.layers = make(map[string]*picture)
+			}
+			result.layers[name] = cp
+		}
+	}
+	return result
+}
+
+// walk calls fn for every path and label in drawing order, descending
+// into groups.
+func (p *picture) walk(fn func(it *pictureItem)) {
+	for _, it := range p.items {
+		if it.group != nil {
+			it.group.walk(fn)
+		} else {
+			fn(it)
+		}
+	}
+}
+
+// paths returns all paths of the picture in drawing order.
+func (p *picture) paths() []*mp.Path {
+	var paths []*mp.Path
+	p.walk(func(it *pictureItem) {
+		if it.path != nil {
+			paths = append(paths, it.path)
+		}
+	})
+	return paths
+}
+
+// labels returns all labels of the picture in drawing order.
+func (p *picture) labels() []*mp.Label {
+	var labels []*mp.Label
+	p.walk(func(it *pictureItem) {
+		if it.label != nil {
+			labels = append(labels, it.label)
+		}
+	})
+	return labels
+}
+
+// find returns the picture that directly contains obj (a path, label or
+// group) and the index of the item, searching groups recursively.
+func (p *picture) find(obj any) (*picture, int) {
+	for i, it := range p.items {
+		if it.object() == obj {
+			return p, i
+		}
+		if it.group != nil {
+			if parent, j := it.group.find(obj); parent != nil {
+				return parent, j
+			}
+		}
+	}
+	return nil, -1
+}
+
+// restack moves obj within its parent: to the top (or directly above ref)
+// if up is true, else to the bottom (or directly below ref). ref must be
+// in the same parent. It reports whether obj was found.
+func (p *picture) restack(obj, ref any, up bool) bool {
+	parent, i := p.find(obj)
+	if parent == nil {
+		return false
+	}
+	it := parent.items[i]
+	items := append(parent.items[:i:i], parent.items[i+1:]...)
+
+	pos := len(items)
+	if !up {
+		pos = 0
+	}
+	if ref != nil {
+		for j, other := range items {
+			if other.object() == ref {
+				pos = j
+				if up {
+					pos = j + 1
+				}
+				break
+			}
+		}
+	}
+	items = append(items[:pos], append([]*pictureItem{it}, items[pos:]...)...)
+	parent.items = items
+	return true
+}
+
+// convertLabels replaces all labels by their glyph outlines.
+func (p *picture) convertLabels(f mp.FontRenderer) error {
+	for i := 0; i < len(p.items); i++ {
+		it := p.items[i]
+		if it.group != nil {
+			if err := it.group.convertLabels(f); err != nil {
+				return err
+			}
+			continue
+		}
+		if it.label == nil {
+			continue
+		}
+		outlines, err := it.label.ToPaths(f)
+		if err != nil {
+			return err
+		}
+		glyphs := &picture{id: it.id, class: it.class}
+		for _, o := range outlines {
+			glyphs.addPath(o)
+		}
+		p.items[i] = &pictureItem{group: glyphs}
+	}
+	return nil
+}
+
+// transformStyle transforms the pen of s and scales its stroke width and
+// dash pattern, as MetaPost transforms the pens and dashes of a picture.
+// Widths that are not set are not scaled. The dash pattern is copied, as
+// other paths may share it.
+func transformStyle(s *mp.Style, t mp.Transform) {
+	scale := math.Sqrt(math.Abs(t.Determinant()))
+	s.Pen = transformPen(s.Pen, t)
+	s.StrokeWidth *= scale
+	if s.Dash != nil {
+		d := &mp.DashPattern{Array: make([]float64, len(s.Dash.Array)), Offset: s.Dash.Offset * scale}
+		for i, v := range s.Dash.Array {
+			d.Array[i] = v * scale
+		}
+		s.Dash = d
+	}
+}
+
+// transformed returns r with its style transformed by t, see
+// transformStyle. Role names refer to the theme and stay as they are.
+func (r styleRef) transformed(t mp.Transform) styleRef {
+	if r.style == nil {
+		return r
+	}
+	s := *r.style
+	transformStyle(&s.Style, t)
+	return styleRef{style: &s}
+}
+
+// transform replaces all paths, clipping paths, bounds and label positions of p
+// and its groups by transformed copies.
+func (p *picture) transform(t mp.Transform) {
+	if p.clip != nil && p.clip.Head != nil {
+		p.clip = t.ApplyToPath(p.clip)
+	}
+	if p.bounds != nil && p.bounds.Head != nil {
+		p.bounds = t.ApplyToPath(p.bounds)
+	}
+	p.style = p.style.transformed(t)
+	for _, it := range p.items {
+		it.style = it.style.transformed(t)
+		switch {
+		case it.group != nil:
+			it.group.transform(t)
+		case it.path != nil && it.path.Head != nil:
+			it.path = transformPath(it.path, t)
+			transformStyle(&it.path.Style, t)
+		case it.label != nil:
+			moved := *it.label
+			moved.Position.X, moved.Position.Y = t.ApplyToPoint(moved.Position.X, moved.Position.Y)
+			it.label = &moved
+		}
+	}
+}
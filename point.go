@@ -105,6 +105,9 @@ func pointIndex(l *lua.State) int {
 }
 
 func pointAdd(l *lua.State) int {
+	if isSolverOperand(l, 1) || isSolverOperand(l, 2) {
+		return exprAdd(l)
+	}
 	a := checkPoint(l, 1)
 	b := checkPoint(l, 2)
 	pushPoint(l, a.Add(b))
@@ -112,6 +115,9 @@ func pointAdd(l *lua.State) int {
 }
 
 func pointSub(l *lua.State) int {
+	if isSolverOperand(l, 1) || isSolverOperand(l, 2) {
+		return exprSub(l)
+	}
 	a := checkPoint(l, 1)
 	b := checkPoint(l, 2)
 	pushPoint(l, a.Sub(b))
@@ -119,6 +125,9 @@ func pointSub(l *lua.State) int {
 }
 
 func pointMul(l *lua.State) int {
+	if isSolverOperand(l, 1) || isSolverOperand(l, 2) {
+		return exprMul(l)
+	}
 	// Handle both point * number and number * point
 	if l.IsNumber(1) {
 		n := lua.CheckNumber(l, 1)
@@ -0,0 +1,294 @@
+package hobby
+
+import (
+	"math"
+
+	"github.com/boxesandglue/mpgo/draw"
+	"github.com/boxesandglue/mpgo/mp"
+	lua "github.com/speedata/go-lua"
+)
+
+// shapePath collects explicit knots for the shape constructors. All
+// segments get their control points right away, so the resulting paths
+// need no solving.
+type shapePath struct {
+	path *mp.Path
+}
+
+// cur returns the current point (the last knot).
+func (s *shapePath) cur() *mp.Knot {
+	return s.path.Head.Prev
+}
+
+func (s *shapePath) moveTo(pt mp.Point) {
+	s.path = mp.NewPath()
+	k := mp.NewKnot()
+	k.XCoord, k.YCoord = pt.X, pt.Y
+	k.LeftX, k.LeftY = pt.X, pt.Y
+	k.RightX, k.RightY = pt.X, pt.Y
+	k.LType = mp.KnotEndpoint
+	k.RType = mp.KnotEndpoint
+	s.path.Append(k)
+}
+
+func (s *shapePath) curveTo(c1, c2, pt mp.Point) {
+	last := s.cur()
+	last.RightX, last.RightY = c1.X, c1.Y
+	last.RType = mp.KnotExplicit
+	k := mp.NewKnot()
+	k.XCoord, k.YCoord = pt.X, pt.Y
+	k.LeftX, k.LeftY = c2.X, c2.Y
+	k.RightX, k.RightY = pt.X, pt.Y
+	k.LType = mp.KnotExplicit
+	k.RType = mp.KnotEndpoint
+	s.path.Append(k)
+}
+
+// lineTo adds a straight segment with the control points at a third of
+// the way, so that directions along the segment are well defined.
+func (s *shapePath) lineTo(pt mp.Point) {
+	last := s.cur()
+	from := mp.P(last.XCoord, last.YCoord)
+	s.curveTo(mp.PointBetween(from, pt, 1.0/3), mp.PointBetween(from, pt, 2.0/3), pt)
+}
+
+// arcTo adds a circular arc around c with radius r from angle a1 to a2
+// (degrees). The current point is expected to be at angle a1. The arc is
+// split into pieces of at most 90 degrees.
+func (s *shapePath) arcTo(c mp.Point, r, a1, a2 float64) {
+	n := int(math.Ceil(math.Abs(a2-a1)/90 - 1e-9))
+	if n == 0 {
+		return
+	}
+	step := (a2 - a1) / float64(n) * math.Pi / 180
+	k := 4.0 / 3 * math.Tan(step/4)
+	angle := a1 * math.Pi / 180
+	for i := 0; i < n; i++ {
+		next := angle + step
+		sin0, cos0 := math.Sincos(angle)
+		sin1, cos1 := math.Sincos(next)
+		c1 := mp.P(c.X+r*(cos0-k*sin0), c.Y+r*(sin0+k*cos0))
+		c2 := mp.P(c.X+r*(cos1+k*sin1), c.Y+r*(sin1-k*cos1))
+		s.curveTo(c1, c2, mp.P(c.X+r*cos1, c.Y+r*sin1))
+		angle = next
+	}
+}
+
+// cycle closes the path. If the current point coincides with the start,
+// the two knots are merged; otherwise a straight segment is added.
+func (s *shapePath) cycle() *mp.Path {
+	head, last := s.path.Head, s.cur()
+	if last != head && math.Hypot(last.XCoord-head.XCoord, last.YCoord-head.YCoord) <= joinTolerance {
+		head.LeftX, head.LeftY = last.LeftX, last.LeftY
+		last.Prev.Next = head
+		head.Prev = last.Prev
+	} else {
+		s.lineTo(mp.P(head.XCoord, head.YCoord))
+		return s.cycle()
+	}
+	head.LType = mp.KnotExplicit
+	head.Prev.RType = mp.KnotExplicit
+	return s.path
+}
+
+// polygonPath returns a closed path through the points, joined by lines.
+func polygonPath(pts []mp.Point) *mp.Path {
+	s := &shapePath{}
+	s.moveTo(pts[0])
+	for _, pt := range pts[1:] {
+		s.lineTo(pt)
+	}
+	return s.cycle()
+}
+
+// polarPoint returns the point at angle deg (degrees) and distance r from c.
+func polarPoint(c mp.Point, r, deg float64) mp.Point {
+	sin, cos := math.Sincos(deg * math.Pi / 180)
+	return mp.P(c.X+r*cos, c.Y+r*sin)
+}
+
+// luaRect creates a rectangle: h.rect(x, y, w, h[, {radius=r}])
+// (x, y) is the lower left corner. With a radius the corners are rounded
+// by quarter circles. The path starts at the lower edge and runs
+// counterclockwise.
+func luaRect(l *lua.State) int {
+	x := lua.CheckNumber(l, 1)
+	y := lua.CheckNumber(l, 2)
+	w := lua.CheckNumber(l, 3)
+	ht := lua.CheckNumber(l, 4)
+	if !l.IsNoneOrNil(5) {
+		lua.CheckType(l, 5, lua.TypeTable)
+	}
+	r := optNumberField(l, 5, "radius", 0)
+	if w < 0 {
+		x, w = x+w, -w
+	}
+	if ht < 0 {
+		y, ht = y+ht, -ht
+	}
+	r = math.Max(0, math.Min(r, math.Min(w, ht)/2))
+
+	if r == 0 {
+		pushPath(l, polygonPath([]mp.Point{
+			mp.P(x, y), mp.P(x+w, y), mp.P(x+w, y+ht), mp.P(x, y+ht),
+		}))
+		return 1
+	}
+
+	// sides that the corners use up entirely are left out
+	s := &shapePath{}
+	side := func(length float64, pt mp.Point) {
+		if length > 2*r {
+			s.lineTo(pt)
+		}
+	}
+	s.moveTo(mp.P(x+r, y))
+	side(w, mp.P(x+w-r, y))
+	s.arcTo(mp.P(x+w-r, y+r), r, -90, 0)
+	side(ht, mp.P(x+w, y+ht-r))
+	s.arcTo(mp.P(x+w-r, y+ht-r), r, 0, 90)
+	side(w, mp.P(x+r, y+ht))
+	s.arcTo(mp.P(x+r, y+ht-r), r, 90, 180)
+	side(ht, mp.P(x, y+r))
+	s.arcTo(mp.P(x+r, y+r), r, 180, 270)
+	pushPath(l, s.cycle())
+	return 1
+}
+
+// luaEllipse creates an ellipse: h.ellipse(center, rx, ry)
+// Like fullcircle it has eight knots and starts at the right.
+func luaEllipse(l *lua.State) int {
+	c := checkPoint(l, 1)
+	rx := lua.CheckNumber(l, 2)
+	ry := lua.OptNumber(l, 3, rx)
+	path := mp.FullCircle().XScaled(2*rx).YScaled(2*ry).Shifted(c.X, c.Y)
+	pushPath(l, path)
+	return 1
+}
+
+// luaArc creates a circular arc: h.arc(center, r, a1, a2)
+// The angles are in degrees; the arc runs counterclockwise if a2 > a1 and
+// clockwise otherwise.
+func luaArc(l *lua.State) int {
+	c := checkPoint(l, 1)
+	r := lua.CheckNumber(l, 2)
+	a1 := lua.CheckNumber(l, 3)
+	a2 := lua.CheckNumber(l, 4)
+	s := &shapePath{}
+	s.moveTo(polarPoint(c, r, a1))
+	s.arcTo(c, r, a1, a2)
+	pushPath(l, s.path)
+	return 1
+}
+
+// luaPolygon creates a regular polygon: h.polygon(n, r)
+// The polygon is centered at the origin with its first corner at the top.
+func luaPolygon(l *lua.State) int {
+	n := lua.CheckInteger(l, 1)
+	r := lua.CheckNumber(l, 2)
+	if n < 3 {
+		lua.Errorf(l, "polygon needs at least 3 corners, got %d", n)
+		return 0
+	}
+	pts := make([]mp.Point, n)
+	for i := range pts {
+		pts[i] = polarPoint(mp.P(0, 0), r, 90+360*float64(i)/float64(n))
+	}
+	pushPath(l, polygonPath(pts))
+	return 1
+}
+
+// luaStar creates a star: h.star(n, r1, r2)
+// The n outer points lie on radius r1, the inner corners on radius r2. The
+// star is centered at the origin with its first point at the top.
+func luaStar(l *lua.State) int {
+	n := lua.CheckInteger(l, 1)
+	r1 := lua.CheckNumber(l, 2)
+	r2 := lua.CheckNumber(l, 3)
+	if n < 2 {
+		lua.Errorf(l, "star needs at least 2 points, got %d", n)
+		return 0
+	}
+	pts := make([]mp.Point, 2*n)
+	for i := range pts {
+		r := r1
+		if i%2 == 1 {
+			r = r2
+		}
+		pts[i] = polarPoint(mp.P(0, 0), r, 90+180*float64(i)/float64(n))
+	}
+	pushPath(l, polygonPath(pts))
+	return 1
+}
+
+// luaSuperellipse creates a superellipse: h.superellipse(center, rx, ry[, s])
+// This is MetaPost's superellipse(r, t, l, b, s) with the four points
+// taken from the bounding box. The superness s defaults to 0.75;
+// 1/sqrt(2) gives an ellipse, values near 1 approach a rectangle.
+func luaSuperellipse(l *lua.State) int {
+	c := checkPoint(l, 1)
+	rx := lua.CheckNumber(l, 2)
+	ry := lua.CheckNumber(l, 3)
+	s := lua.OptNumber(l, 4, 0.75)
+
+	r := mp.P(c.X+rx, c.Y)
+	t := mp.P(c.X, c.Y+ry)
+	lft := mp.P(c.X-rx, c.Y)
+	b := mp.P(c.X, c.Y-ry)
+	// corner returns s[xpart h, xpart v], s[ypart v, ypart h]
+	corner := func(h, v mp.Point) mp.Point {
+		return mp.P(h.X+s*(v.X-h.X), v.Y+s*(h.Y-v.Y))
+	}
+	angle := func(from, to mp.Point) float64 { return to.Sub(from).Angle() }
+
+	path, err := draw.NewPath().
+		MoveTo(r).
+		WithTensionAtLeast(1).CurveToDir(corner(t, r), 90, angle(r, t)).
+		WithTensionAtLeast(1).CurveToDir(t, angle(r, t), 180).
+		WithTensionAtLeast(1).CurveToDir(corner(t, lft), 180, angle(t, lft)).
+		WithTensionAtLeast(1).CurveToDir(lft, angle(t, lft), 270).
+		WithTensionAtLeast(1).CurveToDir(corner(b, lft), 270, angle(lft, b)).
+		WithTensionAtLeast(1).CurveToDir(b, angle(lft, b), 0).
+		WithTensionAtLeast(1).CurveToDir(corner(b, r), 0, angle(b, r)).
+		WithDirection(angle(b, r)).WithIncomingDirection(90).WithTensionAtLeast(1).
+		Close().
+		Solve()
+	if err != nil {
+		lua.Errorf(l, "superellipse: %s", err.Error())
+		return 0
+	}
+	pushPath(l, path)
+	return 1
+}
+
+// luaSpiral creates an Archimedean spiral: h.spiral(center, r1, r2, turns)
+// The radius grows linearly from r1 to r2. The spiral starts at angle 0
+// and winds counterclockwise; negative turns wind clockwise. The curve
+// passes through eight knots per turn.
+func luaSpiral(l *lua.State) int {
+	c := checkPoint(l, 1)
+	r1 := lua.CheckNumber(l, 2)
+	r2 := lua.CheckNumber(l, 3)
+	turns := lua.CheckNumber(l, 4)
+	if turns == 0 {
+		lua.Errorf(l, "spiral needs a non-zero number of turns")
+		return 0
+	}
+	n := int(math.Ceil(math.Abs(turns) * 8))
+	pb := draw.NewPath().MoveTo(polarPoint(c, r1, 0))
+	for i := 1; i <= n; i++ {
+		f := float64(i) / float64(n)
+		pb.CurveTo(polarPoint(c, r1+f*(r2-r1), 360*turns*f))
+	}
+	path, err := pb.Solve()
+	if err != nil {
+		lua.Errorf(l, "spiral: %s", err.Error())
+		return 0
+	}
+	// The solver leaves boundary data in the outer controls of the ends.
+	first, last := path.Head, path.Head.Prev
+	first.LeftX, first.LeftY = first.XCoord, first.YCoord
+	last.RightX, last.RightY = last.XCoord, last.YCoord
+	pushPath(l, path)
+	return 1
+}
@@ -0,0 +1,181 @@
+package hobby
+
+import (
+	"math"
+	"sort"
+)
+
+// The context solver works like MetaPost's: every equation is used at
+// once to eliminate one unknown, which then depends linearly on the
+// remaining independent unknowns. A variable is known as soon as its
+// dependency has no unknowns left, so values are available while the
+// equations are still being given, and an equation that reduces to 0 = c
+// is redundant (c = 0) or inconsistent.
+
+// Tolerances of the solver: coefficients below solverEps are dropped, and
+// an equation that is off by less than solverTolerance is redundant.
+const (
+	solverEps       = 1e-9
+	solverTolerance = 1e-6
+)
+
+// linExpr is a linear expression: the sum of terms[i] times unknown i,
+// plus constant.
+type linExpr struct {
+	terms    map[int]float64
+	constant float64
+}
+
+// newLinExpr returns the constant expression c.
+func newLinExpr(c float64) *linExpr {
+	return &linExpr{terms: map[int]float64{}, constant: c}
+}
+
+// addTerm adds c times unknown i to e.
+func (e *linExpr) addTerm(i int, c float64) {
+	e.terms[i] += c
+}
+
+// addExpr adds factor times f to e.
+func (e *linExpr) addExpr(f *linExpr, factor float64) {
+	for i, c := range f.terms {
+		e.terms[i] += factor * c
+	}
+	e.constant += factor * f.constant
+}
+
+// prune drops the terms with a coefficient of (almost) zero.
+func (e *linExpr) prune() {
+	for i, c := range e.terms {
+		if math.Abs(c) < solverEps {
+			delete(e.terms, i)
+		}
+	}
+}
+
+// unknowns returns the unknowns of e, sorted.
+func (e *linExpr) unknowns() []int {
+	idx := make([]int, 0, len(e.terms))
+	for i := range e.terms {
+		idx = append(idx, i)
+	}
+	sort.Ints(idx)
+	return idx
+}
+
+// equationStatus tells what an equation contributed.
+type equationStatus int
+
+const (
+	equationUsed         equationStatus = iota // eliminated an unknown
+	equationRedundant                          // followed from earlier equations
+	equationInconsistent                       // contradicted earlier equations
+)
+
+// solver holds the unknowns of a context and their dependencies.
+type solver struct {
+	// deps[i] is unknown i in terms of independent unknowns, nil while i
+	// is independent itself.
+	deps []*linExpr
+}
+
+// newVar adds an independent unknown and returns its index.
+func (s *solver) newVar() int {
+	s.deps = append(s.deps, nil)
+	return len(s.deps) - 1
+}
+
+// reduce returns e in terms of independent unknowns.
+func (s *solver) reduce(e *linExpr) *linExpr {
+	r := newLinExpr(e.constant)
+	for i, c := range e.terms {
+		if d := s.deps[i]; d != nil {
+			r.addExpr(d, c)
+		} else {
+			r.addTerm(i, c)
+		}
+	}
+	r.prune()
+	return r
+}
+
+// equate adds the equation e = 0. For an inconsistent equation off is the
+// amount by which it is violated.
+func (s *solver) equate(e *linExpr) (status equationStatus, off float64) {
+	r := s.reduce(e)
+	if len(r.terms) == 0 {
+		if math.Abs(r.constant) > solverTolerance {
+			return equationInconsistent, r.constant
+		}
+		return equationRedundant, 0
+	}
+	// Eliminate the unknown with the largest coefficient, like MetaPost.
+	pivot := -1
+	for _, i := range r.unknowns() {
+		if pivot < 0 || math.Abs(r.terms[i]) > math.Abs(r.terms[pivot]) {
+			pivot = i
+		}
+	}
+	c := r.terms[pivot]
+	delete(r.terms, pivot)
+	dep := newLinExpr(0)
+	dep.addExpr(r, -1/c)
+	for _, d := range s.deps {
+		if d == nil {
+			continue
+		}
+		if cp, ok := d.terms[pivot]; ok {
+			delete(d.terms, pivot)
+			d.addExpr(dep, cp)
+			d.prune()
+		}
+	}
+	s.deps[pivot] = dep
+	return equationUsed, 0
+}
+
+// assign makes unknown i known with value v, like MetaPost's ":=".
+// Variables that depended on the old value keep depending on it as an
+// anonymous unknown: anon if it is not negative, otherwise a new one. It
+// returns the anonymous unknown, -1 if i was not independent.
+func (s *solver) assign(i int, v float64, anon int) int {
+	if s.deps[i] != nil {
+		s.deps[i] = newLinExpr(v)
+		return -1
+	}
+	if anon < 0 {
+		anon = s.newVar()
+	}
+	for _, d := range s.deps {
+		if d == nil {
+			continue
+		}
+		if c, ok := d.terms[i]; ok {
+			delete(d.terms, i)
+			d.terms[anon] = c
+		}
+	}
+	s.deps[i] = newLinExpr(v)
+	return anon
+}
+
+// reset makes all unknowns independent again.
+func (s *solver) reset() {
+	for i := range s.deps {
+		s.deps[i] = nil
+	}
+}
+
+// value returns the value of unknown i; ok is false while it is unknown.
+func (s *solver) value(i int) (v float64, ok bool) {
+	if d := s.deps[i]; d != nil && len(d.terms) == 0 {
+		return d.constant, true
+	}
+	return 0, false
+}
+
+// valueOf returns the value of e; ok is false if it has unknowns.
+func (s *solver) valueOf(e *linExpr) (v float64, ok bool) {
+	r := s.reduce(e)
+	return r.constant, len(r.terms) == 0
+}
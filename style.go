@@ -0,0 +1,566 @@
+package hobby
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/boxesandglue/mpgo/mp"
+	lua "github.com/speedata/go-lua"
+)
+
+// Styles are mp.Style values with flags for the fields that are set, so
+// a style can be laid over another one and a field set to zero (a stroke
+// width of 0, arrows turned off) still overrides. Paths keep their own
+// style, where a zero field means "not set"; styles of items, pictures and
+// the theme are applied when the SVG is written, which makes it possible
+// to render the same figure with different themes.
+
+// styleFields is a set of style fields. The stroke and fill paints need
+// no flags: an unset paint is the zero mp.Color, see overlayPaint.
+type styleFields uint
+
+const (
+	fieldStrokeWidth styleFields = 1 << iota
+	fieldPen
+	fieldDash
+	fieldLineJoin
+	fieldLineCap
+	fieldArrowStart
+	fieldArrowEnd
+	fieldArrowLength
+	fieldArrowAngle
+)
+
+// styleSpec is a style and the fields of it that are set.
+type styleSpec struct {
+	mp.Style
+	set styleFields
+}
+
+// pathStyleSpec returns the style of a path, in which the fields that are
+// not zero are set.
+func pathStyleSpec(s mp.Style) styleSpec {
+	spec := styleSpec{Style: s}
+	flags := []struct {
+		set   bool
+		field styleFields
+	}{
+		{s.StrokeWidth > 0, fieldStrokeWidth},
+		{s.Pen != nil, fieldPen},
+		{s.Dash != nil, fieldDash},
+		{s.LineJoin != mp.LineJoinDefault, fieldLineJoin},
+		{s.LineCap != mp.LineCapDefault, fieldLineCap},
+		{s.Arrow.Start, fieldArrowStart},
+		{s.Arrow.End, fieldArrowEnd},
+		{s.Arrow.Length > 0, fieldArrowLength},
+		{s.Arrow.Angle > 0, fieldArrowAngle},
+	}
+	for _, f := range flags {
+		if f.set {
+			spec.set |= f.field
+		}
+	}
+	return spec
+}
+
+// overlayStyle copies all fields that are set in s to dst.
+func overlayStyle(dst *mp.Style, s styleSpec) {
+	dst.Stroke = overlayPaint(dst.Stroke, s.Stroke)
+	dst.Fill = overlayPaint(dst.Fill, s.Fill)
+	if s.set&fieldStrokeWidth != 0 {
+		dst.StrokeWidth = s.StrokeWidth
+	}
+	if s.set&fieldPen != 0 {
+		dst.Pen = s.Pen
+	}
+	if s.set&fieldDash != 0 {
+		dst.Dash = s.Dash
+	}
+	if s.set&fieldLineJoin != 0 {
+		dst.LineJoin = s.LineJoin
+	}
+	if s.set&fieldLineCap != 0 {
+		dst.LineCap = s.LineCap
+	}
+	if s.set&fieldArrowStart != 0 {
+		dst.Arrow.Start = s.Arrow.Start
+	}
+	if s.set&fieldArrowEnd != 0 {
+		dst.Arrow.End = s.Arrow.End
+	}
+	if s.set&fieldArrowLength != 0 {
+		dst.Arrow.Length = s.Arrow.Length
+	}
+	if s.set&fieldArrowAngle != 0 {
+		dst.Arrow.Angle = s.Arrow.Angle
+	}
+	if dst.Arrow.Start || dst.Arrow.End {
+		if dst.Arrow.Length == 0 {
+			dst.Arrow.Length = mp.DefaultAHLength
+		}
+		if dst.Arrow.Angle == 0 {
+			dst.Arrow.Angle = mp.DefaultAHAngle
+		}
+	}
+}
+
+// with returns spec with the fields of s laid over it.
+func (spec styleSpec) with(s styleSpec) styleSpec {
+	overlayStyle(&spec.Style, s)
+	spec.set |= s.set
+	return spec
+}
+
+// buildStyled applies the style collected by a path builder to the solved
+// path.
+func buildStyled(path *mp.Path, s styleSpec) {
+	overlayStyle(&path.Style, s)
+}
+
+// penEnvelope returns the outline swept by the pen of p when p is drawn
+// with a polygonal pen (pensquare, penrazor, makepen), like MetaPost's
+// envelope. SVG can only stroke with round pens, so the envelope is
+// filled with the stroke paint instead. It is computed from the path and
+// pen as they are drawn, so it follows transformations and later pen
+// changes. nil for elliptical pens and paths that are not stroked.
+func penEnvelope(p *mp.Path) *mp.Path {
+	pen := p.Style.Pen
+	if pen == nil || pen.Head == nil || pen.Elliptical || p.Head == nil || !isStroked(p) {
+		return nil
+	}
+	env := mp.OffsetOutline(p, pen)
+	if env == nil || env.Head == nil {
+		return nil
+	}
+	env.Style = p.Style
+	env.Style.Fill = strokePaint(p)
+	env.Style.Stroke = mp.ColorCSS("none")
+	env.Style.StrokeWidth = 0
+	env.Style.Pen = nil
+	env.Style.Dash = nil
+	env.Envelope = nil
+	return env
+}
+
+// isStyleMethod reports whether name is one of the styling methods shared
+// by paths and path builders.
+func isStyleMethod(name string) bool {
+	switch name {
+	case "stroke", "strokewidth", "fill", "strokeopacity", "fillopacity", "pen",
+		"dash", "evenly", "withdots", "arrow", "dblarrow", "arrowstyle",
+		"linejoin", "linecap", "withstyle":
+		return true
+	}
+	return false
+}
+
+// checkStyleMethod reads the arguments of the styling method name
+// (starting at index 2) and returns the style it sets.
+func checkStyleMethod(l *lua.State, name string) styleSpec {
+	var s styleSpec
+	switch name {
+	case "stroke":
+		s.Stroke = checkColor(l, 2)
+	case "strokewidth":
+		s.StrokeWidth, s.set = lua.CheckNumber(l, 2), fieldStrokeWidth
+	case "fill":
+		s.Fill = checkColor(l, 2)
+	case "strokeopacity":
+		s.Stroke = opacityPaint(checkOpacity(l, 2))
+	case "fillopacity":
+		s.Fill = opacityPaint(checkOpacity(l, 2))
+	case "pen":
+		s.Pen, s.set = checkPen(l, 2), fieldPen
+	case "dash":
+		s.Dash, s.set = checkDash(l, 2), fieldDash
+	case "evenly":
+		s.Dash, s.set = mp.DashEvenly(), fieldDash
+	case "withdots":
+		s.Dash, s.set = mp.DashWithDots(), fieldDash
+	case "arrow":
+		s.Arrow.End, s.set = true, fieldArrowEnd
+	case "dblarrow":
+		s.Arrow.Start = true
+		s.Arrow.End = true
+		s.set = fieldArrowStart | fieldArrowEnd
+	case "arrowstyle":
+		s.Arrow.Length = lua.CheckNumber(l, 2)
+		s.Arrow.Angle = lua.CheckNumber(l, 3)
+		s.set = fieldArrowLength | fieldArrowAngle
+	case "linejoin":
+		s.LineJoin, s.set = checkLineJoin(l, 2), fieldLineJoin
+	case "linecap":
+		s.LineCap, s.set = checkLineCap(l, 2), fieldLineCap
+	case "withstyle":
+		// withstyle(style) or withstyle{stroke=, ...}
+		s = checkStyle(l, 2)
+	}
+	return s
+}
+
+func checkLineJoin(l *lua.State, index int) int {
+	join := lua.CheckString(l, index)
+	switch join {
+	case "miter":
+		return mp.LineJoinMiter
+	case "round":
+		return mp.LineJoinRound
+	case "bevel":
+		return mp.LineJoinBevel
+	}
+	lua.Errorf(l, "unknown linejoin: %s (use miter, round, bevel)", join)
+	return mp.LineJoinDefault
+}
+
+func checkLineCap(l *lua.State, index int) int {
+	cap := lua.CheckString(l, index)
+	switch cap {
+	case "butt":
+		return mp.LineCapButt
+	case "round":
+		return mp.LineCapRounded
+	case "square":
+		return mp.LineCapSquared
+	}
+	lua.Errorf(l, "unknown linecap: %s (use butt, round, square)", cap)
+	return mp.LineCapDefault
+}
+
+// luaStyle creates a style: h.style{stroke=, fill=, strokeopacity=,
+// fillopacity=, width=, pen=, dash=, arrow=, arrowlength=, arrowangle=,
+// join=, cap=}
+// dash is a dash pattern or "evenly"/"withdots"; arrow is true, "end",
+// "start" or "both". The opacities apply to the stroke and fill colors,
+// also to colors inherited from other styles.
+func luaStyle(l *lua.State) int {
+	pushStyle(l, checkStyleTable(l, 1, "style"))
+	return 1
+}
+
+// styleKeys are the keys of style tables.
+var styleKeys = []string{"stroke", "fill", "strokeopacity", "fillopacity", "width", "pen",
+	"dash", "arrow", "arrowlength", "arrowangle", "join", "cap"}
+
+// checkStyleTable reads a style from the table at index. Keys other than
+// those of a style and the given extra keys are an error; what names the
+// table in the message.
+func checkStyleTable(l *lua.State, index int, what string, extra ...string) styleSpec {
+	lua.CheckType(l, index, lua.TypeTable)
+	checkTableKeys(l, index, what, append(extra, styleKeys...))
+	var s styleSpec
+	l.Field(index, "stroke")
+	if !l.IsNil(-1) {
+		s.Stroke = checkColor(l, l.Top())
+	}
+	l.Pop(1)
+	l.Field(index, "fill")
+	if !l.IsNil(-1) {
+		s.Fill = checkColor(l, l.Top())
+	}
+	l.Pop(1)
+	l.Field(index, "strokeopacity")
+	if !l.IsNil(-1) {
+		s.Stroke = withOpacity(s.Stroke, checkOpacity(l, l.Top()))
+	}
+	l.Pop(1)
+	l.Field(index, "fillopacity")
+	if !l.IsNil(-1) {
+		s.Fill = withOpacity(s.Fill, checkOpacity(l, l.Top()))
+	}
+	l.Pop(1)
+	l.Field(index, "width")
+	if !l.IsNil(-1) {
+		s.StrokeWidth, s.set = lua.CheckNumber(l, l.Top()), s.set|fieldStrokeWidth
+	}
+	l.Pop(1)
+	l.Field(index, "pen")
+	if !l.IsNil(-1) {
+		s.Pen, s.set = checkPen(l, l.Top()), s.set|fieldPen
+	}
+	l.Pop(1)
+	l.Field(index, "dash")
+	switch {
+	case l.IsNil(-1):
+	case l.IsString(-1):
+		name, _ := l.ToString(-1)
+		switch name {
+		case "evenly":
+			s.Dash = mp.DashEvenly()
+		case "withdots":
+			s.Dash = mp.DashWithDots()
+		default:
+			lua.Errorf(l, "unknown dash pattern: %s (use evenly, withdots)", name)
+		}
+		s.set |= fieldDash
+	default:
+		s.Dash, s.set = checkDash(l, l.Top()), s.set|fieldDash
+	}
+	l.Pop(1)
+	l.Field(index, "arrow")
+	if l.IsBoolean(-1) {
+		// false turns off both arrow heads
+		s.Arrow.End = l.ToBoolean(-1)
+		s.set |= fieldArrowEnd
+		if !s.Arrow.End {
+			s.set |= fieldArrowStart
+		}
+	} else if !l.IsNil(-1) {
+		switch lua.CheckString(l, l.Top()) {
+		case "end":
+			s.Arrow.End, s.set = true, s.set|fieldArrowEnd
+		case "start":
+			s.Arrow.Start, s.set = true, s.set|fieldArrowStart
+		case "both":
+			s.Arrow.Start, s.Arrow.End = true, true
+			s.set |= fieldArrowStart | fieldArrowEnd
+		default:
+			lua.Errorf(l, "unknown arrow: %s (use end, start, both)", lua.CheckString(l, l.Top()))
+		}
+	}
+	l.Pop(1)
+	l.Field(index, "arrowlength")
+	if !l.IsNil(-1) {
+		s.Arrow.Length, s.set = lua.CheckNumber(l, l.Top()), s.set|fieldArrowLength
+	}
+	l.Pop(1)
+	l.Field(index, "arrowangle")
+	if !l.IsNil(-1) {
+		s.Arrow.Angle, s.set = lua.CheckNumber(l, l.Top()), s.set|fieldArrowAngle
+	}
+	l.Pop(1)
+	l.Field(index, "join")
+	if !l.IsNil(-1) {
+		s.LineJoin, s.set = checkLineJoin(l, l.Top()), s.set|fieldLineJoin
+	}
+	l.Pop(1)
+	l.Field(index, "cap")
+	if !l.IsNil(-1) {
+		s.LineCap, s.set = checkLineCap(l, l.Top()), s.set|fieldLineCap
+	}
+	l.Pop(1)
+	return s
+}
+
+// checkTableKeys raises an error if the table at index has a key that is
+// not in keys. what names the table in the message.
+func checkTableKeys(l *lua.State, index int, what string, keys []string) {
+	l.PushNil()
+	for l.Next(index) {
+		l.Pop(1)
+		if l.TypeOf(-1) != lua.TypeString {
+			lua.Errorf(l, "%s: keys must be strings, not %s", what, lua.TypeNameOf(l, -1))
+		}
+		if key, _ := l.ToString(-1); !slices.Contains(keys, key) {
+			lua.Errorf(l, "%s: unknown key %s (use %s)", what, key, strings.Join(keys, ", "))
+		}
+	}
+}
+
+// registerStyleMeta registers the metatables for styles and themes
+func registerStyleMeta(l *lua.State) {
+	lua.NewMetaTable(l, "hobby.style")
+	l.PushGoFunction(styleIndex)
+	l.SetField(-2, "__index")
+	l.Pop(1)
+
+	lua.NewMetaTable(l, "hobby.theme")
+	l.PushGoFunction(themeIndex)
+	l.SetField(-2, "__index")
+	l.Pop(1)
+}
+
+// pushStyle pushes a style as userdata
+func pushStyle(l *lua.State, s styleSpec) {
+	ptr := new(styleSpec)
+	*ptr = s
+	l.PushUserData(ptr)
+	lua.SetMetaTableNamed(l, "hobby.style")
+}
+
+// checkStyle accepts a style or a style table at index
+func checkStyle(l *lua.State, index int) styleSpec {
+	if s, ok := l.ToUserData(index).(*styleSpec); ok {
+		return *s
+	}
+	if l.IsTable(index) {
+		return checkStyleTable(l, index, "style")
+	}
+	lua.Errorf(l, "expected style at argument %d", index)
+	return styleSpec{}
+}
+
+func styleIndex(l *lua.State) int {
+	s := checkStyle(l, 1)
+	key := lua.CheckString(l, 2)
+
+	switch key {
+	case "with":
+		// s:with(other | {...}) - a copy of s with the fields of other laid over
+		l.PushGoFunction(func(l *lua.State) int {
+			pushStyle(l, s.with(checkStyle(l, 2)))
+			return 1
+		})
+		return 1
+
+	case "get":
+		// s:get() - the fields as a table, like path:getstyle()
+		l.PushGoFunction(func(l *lua.State) int {
+			pushPathStyle(l, &mp.Path{Style: s.Style})
+			return 1
+		})
+		return 1
+	}
+	return 0
+}
+
+// theme maps role names to styles. The role "default" applies to all
+// paths of a document.
+type theme struct {
+	styles map[string]styleSpec
+}
+
+// luaTheme creates a theme: h.theme{default=style, axis=style, ...}
+// The values are styles or style tables.
+func luaTheme(l *lua.State) int {
+	lua.CheckType(l, 1, lua.TypeTable)
+	t := &theme{styles: make(map[string]styleSpec)}
+	l.PushNil()
+	for l.Next(1) {
+		role, ok := l.ToString(-2)
+		if !ok {
+			lua.Errorf(l, "theme: role names must be strings")
+			return 0
+		}
+		t.styles[role] = checkStyle(l, l.Top())
+		l.Pop(1)
+	}
+	pushTheme(l, t)
+	return 1
+}
+
+func pushTheme(l *lua.State, t *theme) {
+	l.PushUserData(t)
+	lua.SetMetaTableNamed(l, "hobby.theme")
+}
+
+func checkTheme(l *lua.State, index int) *theme {
+	if t, ok := l.ToUserData(index).(*theme); ok {
+		return t
+	}
+	lua.Errorf(l, "expected theme at argument %d", index)
+	return nil
+}
+
+func themeIndex(l *lua.State) int {
+	t := checkTheme(l, 1)
+	key := lua.CheckString(l, 2)
+
+	switch key {
+	case "get":
+		// t:get(role) - the style of a role or nil
+		l.PushGoFunction(func(l *lua.State) int {
+			s, ok := t.styles[lua.CheckString(l, 2)]
+			if !ok {
+				l.PushNil()
+				return 1
+			}
+			pushStyle(l, s)
+			return 1
+		})
+		return 1
+
+	case "roles":
+		// t:roles() - sorted list of role names
+		l.PushGoFunction(func(l *lua.State) int {
+			roles := make([]string, 0, len(t.styles))
+			for role := range t.styles {
+				roles = append(roles, role)
+			}
+			sort.Strings(roles)
+			l.CreateTable(len(roles), 0)
+			for i, role := range roles {
+				l.PushString(role)
+				l.RawSetInt(-2, i+1)
+			}
+			return 1
+		})
+		return 1
+
+	case "with":
+		// t:with{role=style, ...} - a copy with roles replaced or extended
+		l.PushGoFunction(func(l *lua.State) int {
+			lua.CheckType(l, 2, lua.TypeTable)
+			result := &theme{styles: make(map[string]styleSpec, len(t.styles))}
+			for role, s := range t.styles {
+				result.styles[role] = s
+			}
+			l.PushNil()
+			for l.Next(2) {
+				role, _ := l.ToString(-2)
+				result.styles[role] = checkStyle(l, l.Top())
+				l.Pop(1)
+			}
+			pushTheme(l, result)
+			return 1
+		})
+		return 1
+	}
+	return 0
+}
+
+// styleRef refers to a style directly or by a role name of the theme.
+type styleRef struct {
+	style *styleSpec
+	role  string
+}
+
+// checkStyleRef reads a style, style table or role name at index.
+func checkStyleRef(l *lua.State, index int) styleRef {
+	if l.IsString(index) {
+		role, _ := l.ToString(index)
+		return styleRef{role: role}
+	}
+	s := checkStyle(l, index)
+	return styleRef{style: &s}
+}
+
+// resolve returns the referenced style. Roles that are not in the theme
+// resolve to nothing.
+func (r styleRef) resolve(t *theme) (styleSpec, bool) {
+	if r.style != nil {
+		return *r.style, true
+	}
+	if r.role != "" && t != nil {
+		s, ok := t.styles[r.role]
+		return s, ok
+	}
+	return styleSpec{}, false
+}
+
+// styledPath returns p with its own style laid over the inherited style
+// defaults. If there are no defaults p itself is returned.
+func styledPath(p *mp.Path, defaults *mp.Style) *mp.Path {
+	if defaults == nil {
+		return p
+	}
+	styled := *p
+	styled.Style = *defaults
+	overlayStyle(&styled.Style, pathStyleSpec(p.Style))
+	return &styled
+}
+
+// inheritStyle returns the defaults for the contents of a picture or item
+// with the style reference r, given the defaults of the parent.
+func inheritStyle(defaults *mp.Style, r styleRef, t *theme) *mp.Style {
+	s, ok := r.resolve(t)
+	if !ok {
+		return defaults
+	}
+	var result mp.Style
+	if defaults != nil {
+		result = *defaults
+	}
+	overlayStyle(&result, s)
+	return &result
+}
@@ -4,14 +4,12 @@ import (
 	"bytes"
 	"os"
 
-	"github.com/boxesandglue/mpgo/svg"
 	lua "github.com/speedata/go-lua"
 )
 
 // luaNewSVG creates a new SVG builder: hobby.svg()
 func luaNewSVG(l *lua.State) int {
-	builder := svg.NewBuilder()
-	pushSVG(l, builder)
+	pushSVG(l, &svgDocument{root: newPicture(), tiles: statePatternTiles(l)})
 	return 1
 }
 
@@ -31,7 +29,7 @@ func svgIndex(l *lua.State) int {
 	case "add":
 		l.PushGoFunction(func(l *lua.State) int {
 			path := checkPath(l, 2)
-			s.AddPathFromPath(path)
+			s.root.addPath(path)
 			l.PushValue(1) // return self for chaining
 			return 1
 		})
@@ -40,7 +38,7 @@ func svgIndex(l *lua.State) int {
 	case "padding":
 		l.PushGoFunction(func(l *lua.State) int {
 			p := lua.CheckNumber(l, 2)
-			s.Padding(p)
+			s.padding = p
 			l.PushValue(1)
 			return 1
 		})
@@ -69,10 +67,24 @@ func svgIndex(l *lua.State) int {
 		})
 		return 1
 
+	case "theme":
+		// svg:theme(t) - theme used to resolve style roles; its "default"
+		// role applies to all paths
+		l.PushGoFunction(func(l *lua.State) int {
+			if l.IsNoneOrNil(2) {
+				s.theme = nil
+			} else {
+				s.theme = checkTheme(l, 2)
+			}
+			l.PushValue(1)
+			return 1
+		})
+		return 1
+
 	case "addpicture":
 		l.PushGoFunction(func(l *lua.State) int {
 			pic := checkPicture(l, 2)
-			s.AddPicture(pic)
+			s.root.addPicture(pic)
 			l.PushValue(1) // return self for chaining
 			return 1
 		})
@@ -0,0 +1,461 @@
+package hobby
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/boxesandglue/mpgo/mp"
+	"github.com/boxesandglue/mpgo/svg"
+)
+
+// xmlEscaper escapes text and attribute values.
+var xmlEscaper = strings.NewReplacer("<", "&lt;", ">", "&gt;", "&", "&amp;", `"`, "&quot;", "'", "&apos;")
+
+// defaultStrokeWidth is the stroke width of paths without a width or pen
+// (MetaPost's pencircle scaled 0.5pt).
+const defaultStrokeWidth = 0.5
+
+// svgDocument collects the contents of an SVG file: h.svg()
+// The drawing is a picture, so that groups, layers and clipping are
+// written as nested <g> elements.
+type svgDocument struct {
+	root    *picture
+	padding float64
+	theme   *theme
+	tiles   *patternTiles
+}
+
+// svgWriter renders a picture. Definitions (clip paths, ...) are
+// collected in defs while the body is written.
+type svgWriter struct {
+	body    strings.Builder
+	defs    strings.Builder
+	offsetX float64 // x offset so that the viewBox starts at 0
+	maxY    float64 // y coordinates are flipped around maxY
+	nextID  int
+	theme   *theme
+	tiles   *patternTiles
+	paints  map[string]string // gradient or pattern color -> id of its definition
+}
+
+// WriteTo writes the document as SVG. The viewBox is fitted to the
+// content, like svg.Builder does in its MetaPost compatible mode.
+func (d *svgDocument) WriteTo(w io.Writer) (int64, error) {
+	sw := &svgWriter{theme: d.theme, tiles: d.tiles}
+	defaults := inheritStyle(nil, styleRef{role: "default"}, d.theme)
+	var viewBox string
+	if minX, minY, maxX, maxY, ok := contentBounds(d.root, d.padding, defaults, d.theme); ok {
+		sw.offsetX = minX
+		sw.maxY = maxY
+		viewBox = fmt.Sprintf("0 0 %g %g", maxX-minX, maxY-minY)
+	} else {
+		viewBox = "0 0 0 0"
+	}
+	sw.writePicture(d.root, defaults)
+
+	var out strings.Builder
+	fmt.Fprintf(&out, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="%s">`, viewBox)
+	if sw.defs.Len() > 0 {
+		out.WriteString("<defs>")
+		out.WriteString(sw.defs.String())
+		out.WriteString("</defs>")
+	}
+	out.WriteString(sw.body.String())
+	out.WriteString("</svg>\n")
+	n, err := io.WriteString(w, out.String())
+	return int64(n), err
+}
+
+// newID returns a fresh id for a definition.
+func (sw *svgWriter) newID(prefix string) string {
+	id := fmt.Sprintf("%s%d", prefix, sw.nextID)
+	sw.nextID++
+	return id
+}
+
+// paint returns the value of a fill or stroke attribute for c. Gradients
+// and patterns are written to the definitions once and referenced by id;
+// gray, CMYK and spot colors are written as their RGB equivalent.
+func (sw *svgWriter) paint(c mp.Color) string {
+	g, isGradient := gradientOf(c)
+	pat, isPattern := patternOf(c)
+	if !isGradient && !isPattern {
+		return displayColor(c).CSS()
+	}
+	if id, ok := sw.paints[c.CSS()]; ok {
+		return "url(#" + id + ")"
+	}
+	if sw.paints == nil {
+		sw.paints = make(map[string]string)
+	}
+	var id string
+	if isGradient {
+		id = sw.newID("grad")
+		sw.writeGradient(id, g)
+	} else {
+		id = sw.newID("pattern")
+		sw.writePattern(id, pat)
+	}
+	sw.paints[c.CSS()] = id
+	return "url(#" + id + ")"
+}
+
+// writeGradient writes the definition of g. The coordinates stay in user
+// space; the gradient transform maps them to SVG coordinates.
+func (sw *svgWriter) writeGradient(id string, g *gradient) {
+	t := g.Transform
+	// SVG coordinates are (x - offsetX, maxY - y)
+	neg := func(v float64) float64 {
+		if v == 0 {
+			return 0 // avoid "-0"
+		}
+		return -v
+	}
+	matrix := fmt.Sprintf("matrix(%g %g %g %g %g %g)",
+		t.Txx, neg(t.Tyx), t.Txy, neg(t.Tyy), t.Tx-sw.offsetX, sw.maxY-t.Ty)
+	element := "linearGradient"
+	if g.Radial {
+		element = "radialGradient"
+		fmt.Fprintf(&sw.defs, `<radialGradient id="%s" gradientUnits="userSpaceOnUse" cx="%g" cy="%g" r="%g" fx="%g" fy="%g"`,
+			id, g.X2, g.Y2, g.R2, g.X1, g.Y1)
+		if g.R1 > 0 {
+			fmt.Fprintf(&sw.defs, ` fr="%g"`, g.R1)
+		}
+	} else {
+		fmt.Fprintf(&sw.defs, `<linearGradient id="%s" gradientUnits="userSpaceOnUse" x1="%g" y1="%g" x2="%g" y2="%g"`,
+			id, g.X1, g.Y1, g.X2, g.Y2)
+	}
+	fmt.Fprintf(&sw.defs, ` gradientTransform="%s">`, matrix)
+	for _, s := range g.Stops {
+		fmt.Fprintf(&sw.defs, `<stop offset="%g" stop-color="%s"`, s.Offset, xmlEscaper.Replace(s.Color))
+		if s.Opacity < 1 {
+			fmt.Fprintf(&sw.defs, ` stop-opacity="%.3f"`, s.Opacity)
+		}
+		sw.defs.WriteString("/>")
+	}
+	fmt.Fprintf(&sw.defs, "</%s>", element)
+}
+
+// writePattern writes the definition of pat. The tile is written by a
+// writer of its own whose SVG coordinates start at the upper left corner
+// of the tile; the pattern transform maps them to SVG coordinates of the
+// drawing.
+func (sw *svgWriter) writePattern(id string, pat *pattern) {
+	tile := &svgWriter{offsetX: pat.X, maxY: pat.Y + pat.Height, nextID: sw.nextID, theme: sw.theme, tiles: sw.tiles}
+	tile.writePicture(sw.tiles.tile(pat), inheritStyle(nil, styleRef{role: "default"}, sw.theme))
+	sw.nextID = tile.nextID
+	sw.defs.WriteString(tile.defs.String())
+
+	// tile SVG coordinates -> user coordinates -> drawing -> SVG coordinates
+	toUser := mp.Transform{Txx: 1, Tyy: -1, Tx: pat.X, Ty: pat.Y + pat.Height}
+	toSVG := mp.Transform{Txx: 1, Tyy: -1, Tx: -sw.offsetX, Ty: sw.maxY}
+	m := toUser.Then(pat.Transform).Then(toSVG)
+	fmt.Fprintf(&sw.defs, `<pattern id="%s" patternUnits="userSpaceOnUse" width="%g" height="%g" patternTransform="matrix(%g %g %g %g %g %g)">`,
+		id, pat.Width, pat.Height, zeroSign(m.Txx), zeroSign(m.Tyx), zeroSign(m.Txy), zeroSign(m.Tyy), zeroSign(m.Tx), zeroSign(m.Ty))
+	sw.defs.WriteString(tile.body.String())
+	sw.defs.WriteString("</pattern>")
+}
+
+// zeroSign turns -0 into 0, so that it is not written as "-0".
+func zeroSign(v float64) float64 {
+	if v == 0 {
+		return 0
+	}
+	return v
+}
+
+// pathData returns the d attribute for p in SVG coordinates.
+func (sw *svgWriter) pathData(p *mp.Path) string {
+	return svg.PathToSVGTransformed(p, sw.offsetX, 0, sw.maxY)
+}
+
+// writePicture writes the items of pic. Pictures without id, class or
+// clipping path are written without a <g> element. defaults is the style
+// inherited from the theme and the enclosing pictures, or nil.
+func (sw *svgWriter) writePicture(pic *picture, defaults *mp.Style) {
+	defaults = inheritStyle(defaults, pic.style, sw.theme)
+	var attrs string
+	if pic.id != "" {
+		attrs += fmt.Sprintf(` id="%s"`, xmlEscaper.Replace(pic.id))
+	}
+	if pic.class != "" {
+		attrs += fmt.Sprintf(` class="%s"`, xmlEscaper.Replace(pic.class))
+	}
+	if pic.clip != nil && pic.clip.Head != nil {
+		id := sw.newID("clip")
+		fmt.Fprintf(&sw.defs, `<clipPath id="%s"><path d="%s"/></clipPath>`, id, sw.pathData(pic.clip))
+		attrs += fmt.Sprintf(` clip-path="url(#%s)"`, id)
+	}
+	attrs += pic.compositing.attrs()
+	group := attrs != ""
+	if group {
+		fmt.Fprintf(&sw.body, "<g%s>", attrs)
+	}
+	// Like the SVG builder of mpgo the labels of a picture are written
+	// after its paths and groups, so they are not covered by them.
+	for _, it := range pic.items {
+		if it.label == nil {
+			sw.writeItem(it, defaults)
+		}
+	}
+	for _, it := range pic.items {
+		if it.label != nil {
+			sw.writeItem(it, defaults)
+		}
+	}
+	if group {
+		sw.body.WriteString("</g>")
+	}
+}
+
+// writeItem writes a path, label or group of a picture.
+func (sw *svgWriter) writeItem(it *pictureItem, defaults *mp.Style) {
+	// Arrow heads and envelopes are separate elements, so an item with an
+	// opacity or blend mode gets its own group.
+	if it.isSet() {
+		fmt.Fprintf(&sw.body, "<g%s>", it.compositing.attrs())
+	}
+	switch {
+	case it.group != nil:
+		sw.writePicture(it.group, defaults)
+	case it.path != nil:
+		p := styledPath(it.path, inheritStyle(defaults, it.style, sw.theme))
+		sw.writeDrawnPath(p, itemAttrs(it))
+	case it.label != nil:
+		sw.writeLabel(it.label, itemAttrs(it))
+	}
+	if it.isSet() {
+		sw.body.WriteString("</g>")
+	}
+}
+
+// itemAttrs returns the id and class attributes of an item.
+func itemAttrs(it *pictureItem) string {
+	var attrs string
+	if it.id != "" {
+		attrs += fmt.Sprintf(` id="%s"`, xmlEscaper.Replace(it.id))
+	}
+	if it.class != "" {
+		attrs += fmt.Sprintf(` class="%s"`, xmlEscaper.Replace(it.class))
+	}
+	return attrs
+}
+
+// arrowSize returns the arrow head length and angle of p, with defaults.
+func arrowSize(p *mp.Path) (length, angle float64) {
+	length, angle = p.Style.Arrow.Length, p.Style.Arrow.Angle
+	if length <= 0 {
+		length = mp.DefaultAHLength
+	}
+	if angle <= 0 {
+		angle = mp.DefaultAHAngle
+	}
+	return length, angle
+}
+
+// writeDrawnPath writes p as it is drawn: the envelope of a polygonal pen
+// replaces the stroke, and arrow heads shorten the path and are added as
+// filled shapes.
+func (sw *svgWriter) writeDrawnPath(p *mp.Path, attrs string) {
+	if p.Head == nil {
+		return
+	}
+	if envelope := penEnvelope(p); envelope != nil {
+		envelope.Style.Arrow = p.Style.Arrow
+		if !hasPaint(p.Style.Fill) {
+			sw.writeDrawnPath(envelope, attrs)
+			return
+		}
+		// filldraw: the fill lies below the envelope
+		fmt.Fprintf(&sw.body, "<g%s>", attrs)
+		filled := *p
+		filled.Style.Stroke = mp.ColorCSS("none")
+		sw.writePath(&filled, "")
+		sw.writeDrawnPath(envelope, "")
+		sw.body.WriteString("</g>")
+		return
+	}
+	length, angle := arrowSize(p)
+	// The arrow base is at distance ahlength * cos(ahangle/2) from the apex.
+	base := length * math.Cos(angle*math.Pi/360)
+	var shortenStart, shortenEnd float64
+	if p.Style.Arrow.Start {
+		shortenStart = base
+	}
+	if p.Style.Arrow.End {
+		shortenEnd = base
+	}
+	drawn := p
+	if shortenStart > 0 || shortenEnd > 0 {
+		if shortened := mp.ShortenPathForArrow(p, shortenStart, shortenEnd); shortened != nil {
+			drawn = shortened
+		}
+	}
+	sw.writePath(drawn, attrs)
+	if p.Style.Arrow.End {
+		if arrow := mp.ArrowHeadEnd(p, length, angle); arrow != nil {
+			arrow.Style.Fill = strokePaint(p)
+			arrow.Style.Stroke = mp.ColorCSS("none")
+			sw.writePath(arrow, "")
+		}
+	}
+	if p.Style.Arrow.Start {
+		if arrow := mp.ArrowHeadStart(p, length, angle); arrow != nil {
+			arrow.Style.Fill = strokePaint(p)
+			arrow.Style.Stroke = mp.ColorCSS("none")
+			sw.writePath(arrow, "")
+		}
+	}
+}
+
+// writePath writes a single <path> element.
+func (sw *svgWriter) writePath(p *mp.Path, attrs string) {
+	fill := resolvePaint(p.Style.Fill, "none")
+	color := resolvePaint(p.Style.Stroke, "black")
+	d := sw.pathData(p)
+	if color.CSS() == "none" {
+		fmt.Fprintf(&sw.body, `<path%s d="%s" fill="%s" stroke="none"%s/>`,
+			attrs, d, sw.paint(fill), opacityAttrs(fill, "fill"))
+		return
+	}
+	fmt.Fprintf(&sw.body, `<path%s d="%s" fill="%s" stroke="%s" stroke-width="%.2f" stroke-linecap="%s" stroke-linejoin="%s"%s%s%s/>`,
+		attrs, d, sw.paint(fill), sw.paint(color), strokeWidth(p), lineCapName(p.Style.LineCap), lineJoinName(p.Style.LineJoin),
+		svg.FormatDashAttrs(p.Style.Dash), opacityAttrs(fill, "fill"), opacityAttrs(color, "stroke"))
+}
+
+// strokePaint returns the paint p is stroked with, for filling envelopes
+// and arrow heads: black if the stroke is unset or only sets an opacity.
+func strokePaint(p *mp.Path) mp.Color {
+	return resolvePaint(p.Style.Stroke, "black")
+}
+
+// opacityAttrs returns a fill-opacity or stroke-opacity attribute if c
+// has an opacity.
+func opacityAttrs(c mp.Color, kind string) string {
+	if op, ok := colorOpacity(c); ok {
+		return fmt.Sprintf(` %s-opacity="%.3f"`, kind, op)
+	}
+	return ""
+}
+
+// strokeWidth returns the width used to stroke p. Elliptical pens
+// determine the width through their scale.
+func strokeWidth(p *mp.Path) float64 {
+	width := defaultStrokeWidth
+	if p.Style.StrokeWidth > 0 {
+		width = p.Style.StrokeWidth
+	}
+	if pen := p.Style.Pen; pen != nil && pen.Elliptical {
+		if scale := mp.GetPenScale(pen); scale > 0 {
+			width = scale
+		}
+	}
+	return width
+}
+
+func lineCapName(c int) string {
+	switch c {
+	case mp.LineCapButt:
+		return "butt"
+	case mp.LineCapSquared:
+		return "square"
+	}
+	return "round"
+}
+
+func lineJoinName(j int) string {
+	switch j {
+	case mp.LineJoinMiter:
+		return "miter"
+	case mp.LineJoinBevel:
+		return "bevel"
+	}
+	return "round"
+}
+
+// writeLabel writes a label as a <text> element.
+func (sw *svgWriter) writeLabel(label *mp.Label, attrs string) {
+	dx, dy := mp.LabelOffsetVector(label.Anchor)
+	offset := label.LabelOffset
+	if offset == 0 {
+		offset = mp.DefaultLabelOffset
+	}
+	x := label.Position.X + dx*offset - sw.offsetX
+	y := sw.maxY - (label.Position.Y + dy*offset)
+
+	fontSize := label.FontSize
+	if fontSize == 0 {
+		fontSize = mp.DefaultFontSize
+	}
+	fontFamily := label.FontFamily
+	if fontFamily == "" {
+		fontFamily = "sans-serif"
+	}
+	color := label.Color
+	if color.CSS() == "" {
+		color = mp.ColorCSS("black")
+	}
+
+	textAnchor := "middle"
+	xf, yf := mp.LabelAnchorFactors(label.Anchor)
+	if xf < 0.25 {
+		textAnchor = "start"
+	} else if xf > 0.75 {
+		textAnchor = "end"
+	}
+	baseline := "central"
+	if yf < 0.25 {
+		baseline = "text-after-edge"
+	} else if yf > 0.75 {
+		baseline = "hanging"
+	}
+
+	fmt.Fprintf(&sw.body, `<text%s x="%.3f" y="%.3f" font-family="%s" font-size="%.2f" fill="%s" text-anchor="%s" dominant-baseline="%s">%s</text>`,
+		attrs, x, y, xmlEscaper.Replace(fontFamily), fontSize, sw.paint(color), textAnchor, baseline, xmlEscaper.Replace(label.Text))
+}
+
+// contentBounds returns the area covered by the picture including stroke
+// widths, arrow heads, labels and padding. Clipped groups only count
+// inside the bounds of their clipping path, and bounds set with setbounds
+// replace those of the content. ok is false for an empty picture.
+func contentBounds(pic *picture, padding float64, defaults *mp.Style, th *theme) (minX, minY, maxX, maxY float64, ok bool) {
+	var visit func(pic *picture, defaults *mp.Style) bbox
+	visit = func(pic *picture, defaults *mp.Style) bbox {
+		if pic.bounds != nil {
+			return pathBBox(pic.bounds)
+		}
+		defaults = inheritStyle(defaults, pic.style, th)
+		var b bbox
+		for _, it := range pic.items {
+			switch {
+			case it.group != nil:
+				b.addBox(visit(it.group, defaults))
+			case it.label != nil:
+				b.add(it.label.EstimateBounds())
+			case it.path != nil && it.path.Head != nil:
+				p := styledPath(it.path, inheritStyle(defaults, it.style, th))
+				// An envelope is the outline of the drawn stroke, other
+				// strokes reach half their width beyond the path.
+				if envelope := penEnvelope(p); envelope != nil {
+					b.addBox(pathBBox(envelope))
+				} else {
+					pb := pathBBox(p)
+					if isStroked(p) {
+						half := strokeWidth(p) / 2
+						pb = bbox{pb.minX - half, pb.minY - half, pb.maxX + half, pb.maxY + half, true}
+					}
+					b.addBox(pb)
+				}
+				b.addBox(arrowHeadsBBox(p))
+			}
+		}
+		return b.clipped(pic.clip)
+	}
+	b := visit(pic, defaults)
+	if !b.valid {
+		return 0, 0, 0, 0, false
+	}
+	return b.minX - padding, b.minY - padding, b.maxX + padding, b.maxY + padding, true
+}
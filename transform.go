@@ -0,0 +1,305 @@
+package hobby
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/boxesandglue/mpgo/mp"
+	lua "github.com/speedata/go-lua"
+)
+
+// luaTransform creates a transform: h.transform([tx, ty, txx, txy, tyx, tyy])
+// Without arguments this is the identity. The six numbers follow
+// MetaPost's xpart, ypart, xxpart, xypart, yxpart, yypart.
+func luaTransform(l *lua.State) int {
+	t := mp.Identity()
+	if l.Top() > 0 {
+		t.Tx = lua.CheckNumber(l, 1)
+		t.Ty = lua.CheckNumber(l, 2)
+		t.Txx = lua.CheckNumber(l, 3)
+		t.Txy = lua.CheckNumber(l, 4)
+		t.Tyx = lua.CheckNumber(l, 5)
+		t.Tyy = lua.CheckNumber(l, 6)
+	}
+	pushTransform(l, t)
+	return 1
+}
+
+// luaTransformFrom finds the transform that maps three points onto three
+// others: h.transformfrom(p1, p2, p3, q1, q2, q3)
+// This is MetaPost's "p1 transformed T = q1; ..." with T unknown.
+func luaTransformFrom(l *lua.State) int {
+	var p, q [3]mp.Point
+	for i := 0; i < 3; i++ {
+		p[i] = checkPoint(l, i+1)
+		q[i] = checkPoint(l, i+4)
+	}
+	t, ok := transformFrom(p, q)
+	if !ok {
+		lua.Errorf(l, "transformfrom: the source points are collinear")
+		return 0
+	}
+	pushTransform(l, t)
+	return 1
+}
+
+// transformFrom solves q[i] = T(p[i]) for T. The two rows of the matrix
+// are independent 3x3 systems that share the coefficient matrix
+//
+//	| p1.x  p1.y  1 |
+//	| p2.x  p2.y  1 |
+//	| p3.x  p3.y  1 |
+//
+// which is solved with Cramer's rule. ok is false if the points p are
+// collinear.
+func transformFrom(p, q [3]mp.Point) (mp.Transform, bool) {
+	det3 := func(a, b, c [3]float64) float64 {
+		return a[0]*(b[1]*c[2]-b[2]*c[1]) - a[1]*(b[0]*c[2]-b[2]*c[0]) + a[2]*(b[0]*c[1]-b[1]*c[0])
+	}
+	col := func(f func(mp.Point) float64, pts [3]mp.Point) [3]float64 {
+		return [3]float64{f(pts[0]), f(pts[1]), f(pts[2])}
+	}
+	xs := col(func(pt mp.Point) float64 { return pt.X }, p)
+	ys := col(func(pt mp.Point) float64 { return pt.Y }, p)
+	ones := [3]float64{1, 1, 1}
+	d := det3(xs, ys, ones)
+	if math.Abs(d) < 1e-12 {
+		return mp.Identity(), false
+	}
+	solve := func(rhs [3]float64) (float64, float64, float64) {
+		return det3(rhs, ys, ones) / d, det3(xs, rhs, ones) / d, det3(xs, ys, rhs) / d
+	}
+	var t mp.Transform
+	t.Txx, t.Txy, t.Tx = solve(col(func(pt mp.Point) float64 { return pt.X }, q))
+	t.Tyx, t.Tyy, t.Ty = solve(col(func(pt mp.Point) float64 { return pt.Y }, q))
+	return t, true
+}
+
+// registerTransformMeta registers the metatable for transforms
+func registerTransformMeta(l *lua.State) {
+	lua.NewMetaTable(l, "hobby.transform")
+	l.PushGoFunction(transformIndex)
+	l.SetField(-2, "__index")
+
+	// __mul: t1 * t2 applies t1 first, then t2
+	l.PushGoFunction(transformMul)
+	l.SetField(-2, "__mul")
+
+	l.PushGoFunction(transformToString)
+	l.SetField(-2, "__tostring")
+	l.Pop(1)
+}
+
+// pushTransform pushes a transform as userdata
+func pushTransform(l *lua.State, t mp.Transform) {
+	ptr := new(mp.Transform)
+	*ptr = t
+	l.PushUserData(ptr)
+	lua.SetMetaTableNamed(l, "hobby.transform")
+}
+
+// checkTransform checks if value at index is a Transform
+func checkTransform(l *lua.State, index int) mp.Transform {
+	if t, ok := l.ToUserData(index).(*mp.Transform); ok {
+		return *t
+	}
+	lua.Errorf(l, "expected transform at argument %d", index)
+	return mp.Identity()
+}
+
+func transformMul(l *lua.State) int {
+	a := checkTransform(l, 1)
+	b := checkTransform(l, 2)
+	pushTransform(l, a.Then(b))
+	return 1
+}
+
+func transformToString(l *lua.State) int {
+	t := checkTransform(l, 1)
+	l.PushString(fmt.Sprintf("(%g, %g, %g, %g, %g, %g)", t.Tx, t.Ty, t.Txx, t.Txy, t.Tyx, t.Tyy))
+	return 1
+}
+
+// transformPen returns a copy of pen with all knots transformed, like
+// MetaPost's "pen transformed t".
+func transformPen(pen *mp.Pen, t mp.Transform) *mp.Pen {
+	if pen == nil || pen.Head == nil {
+		return pen
+	}
+	result := &mp.Pen{Elliptical: pen.Elliptical}
+	p := mp.NewPath()
+	for _, k := range pathKnots(&mp.Path{Head: pen.Head}) {
+		k = mp.CopyKnot(k)
+		t.ApplyToKnot(k)
+		p.Append(k)
+	}
+	result.Head = p.Head
+	return result
+}
+
+// transformPicture returns a copy of pic with all paths, pens, stroke
+// widths, clipping paths and label positions transformed. Label text keeps
+// its orientation and size unless glyphs is set: then the labels are
+// converted to outline paths with that font and transformed like any
+// other path.
+func transformPicture(pic *picture, t mp.Transform, glyphs mp.FontRenderer) (*picture, error) {
+	result := pic.copy()
+	if glyphs != nil {
+		if err := result.convertLabels(glyphs); err != nil {
+			return nil, err
+		}
+	}
+	result.transform(t)
+	return result, nil
+}
+
+func transformIndex(l *lua.State) int {
+	t := checkTransform(l, 1)
+	key := lua.CheckString(l, 2)
+
+	switch key {
+	case "xpart":
+		l.PushNumber(t.Tx)
+		return 1
+	case "ypart":
+		l.PushNumber(t.Ty)
+		return 1
+	case "xxpart":
+		l.PushNumber(t.Txx)
+		return 1
+	case "xypart":
+		l.PushNumber(t.Txy)
+		return 1
+	case "yxpart":
+		l.PushNumber(t.Tyx)
+		return 1
+	case "yypart":
+		l.PushNumber(t.Tyy)
+		return 1
+	case "determinant":
+		l.PushNumber(t.Determinant())
+		return 1
+
+	case "transformed":
+		// t:transformed(other) - same as t * other
+		l.PushGoFunction(func(l *lua.State) int {
+			pushTransform(l, t.Then(checkTransform(l, 2)))
+			return 1
+		})
+		return 1
+
+	case "inverse":
+		l.PushGoFunction(func(l *lua.State) int {
+			if t.Determinant() == 0 {
+				lua.Errorf(l, "inverse: transform is not invertible")
+				return 0
+			}
+			pushTransform(l, t.Inverse())
+			return 1
+		})
+		return 1
+
+	case "apply":
+		// t:apply(point|path|picture|pen) - returns a transformed copy
+		l.PushGoFunction(func(l *lua.State) int {
+			switch v := l.ToUserData(2).(type) {
+			case *mp.Point:
+				x, y := t.ApplyToPoint(v.X, v.Y)
+				pushPoint(l, mp.P(x, y))
+			case *mp.Path:
+				if v.Head == nil {
+					pushPath(l, v.Copy())
+					return 1
+				}
+				pushPath(l, transformPath(v, t))
+			case *picture:
+				result, _ := transformPicture(v, t, nil)
+				pushPicture(l, result)
+			case *penWrapper:
+				pushPen(l, transformPen(v.pen, t))
+			default:
+				if l.IsTable(2) {
+					p := checkPoint(l, 2)
+					x, y := t.ApplyToPoint(p.X, p.Y)
+					pushPoint(l, mp.P(x, y))
+					return 1
+				}
+				lua.Errorf(l, "apply: expected point, path, picture or pen")
+				return 0
+			}
+			return 1
+		})
+		return 1
+	}
+
+	if isTransformOp(key) {
+		l.PushGoFunction(func(l *lua.State) int {
+			pushTransform(l, t.Then(checkTransformOp(l, key)))
+			return 1
+		})
+		return 1
+	}
+	return 0
+}
+
+// isTransformOp reports whether name is one of the transformation methods
+// shared by transforms, pictures and pens.
+func isTransformOp(name string) bool {
+	switch name {
+	case "scaled", "xscaled", "yscaled", "slanted", "shifted", "rotated",
+		"zscaled", "rotatedaround", "scaledaround", "reflectedabout":
+		return true
+	}
+	return false
+}
+
+// checkTransformOp reads the arguments of the transformation method name
+// (starting at index 2) and returns the corresponding transform. The
+// arguments are the same as for the path methods of the same name.
+func checkTransformOp(l *lua.State, name string) mp.Transform {
+	switch name {
+	case "scaled":
+		return mp.Scaled(lua.CheckNumber(l, 2))
+	case "xscaled":
+		return mp.XScaled(lua.CheckNumber(l, 2))
+	case "yscaled":
+		return mp.YScaled(lua.CheckNumber(l, 2))
+	case "slanted":
+		return mp.Slanted(lua.CheckNumber(l, 2))
+	case "shifted":
+		return mp.Shifted(lua.CheckNumber(l, 2), lua.CheckNumber(l, 3))
+	case "rotated":
+		return mp.Rotated(lua.CheckNumber(l, 2))
+	case "zscaled":
+		// zscaled(a, b) or zscaled(point)
+		if l.IsUserData(2) {
+			p := checkPoint(l, 2)
+			return mp.ZScaled(p.X, p.Y)
+		}
+		return mp.ZScaled(lua.CheckNumber(l, 2), lua.CheckNumber(l, 3))
+	case "rotatedaround":
+		// rotatedaround(point, angle) or rotatedaround(cx, cy, angle)
+		if l.IsUserData(2) {
+			p := checkPoint(l, 2)
+			return mp.RotatedAround(p.X, p.Y, lua.CheckNumber(l, 3))
+		}
+		return mp.RotatedAround(lua.CheckNumber(l, 2), lua.CheckNumber(l, 3), lua.CheckNumber(l, 4))
+	case "scaledaround":
+		// scaledaround(point, scale) or scaledaround(cx, cy, scale)
+		if l.IsUserData(2) {
+			p := checkPoint(l, 2)
+			return mp.ScaledAround(p.X, p.Y, lua.CheckNumber(l, 3))
+		}
+		return mp.ScaledAround(lua.CheckNumber(l, 2), lua.CheckNumber(l, 3), lua.CheckNumber(l, 4))
+	case "reflectedabout":
+		// reflectedabout(p1, p2) or reflectedabout(x1, y1, x2, y2)
+		if l.IsUserData(2) {
+			p1 := checkPoint(l, 2)
+			p2 := checkPoint(l, 3)
+			return mp.ReflectedAbout(p1.X, p1.Y, p2.X, p2.Y)
+		}
+		return mp.ReflectedAbout(lua.CheckNumber(l, 2), lua.CheckNumber(l, 3), lua.CheckNumber(l, 4), lua.CheckNumber(l, 5))
+	}
+	lua.Errorf(l, "unknown transformation: %s", name)
+	return mp.Identity()
+}